@@ -0,0 +1,105 @@
+package agentproto
+
+import (
+	"fmt"
+	"time"
+)
+
+// Certificate types.
+const (
+	UserCert = 1
+	HostCert = 2
+)
+
+// Certificate is OpenSSH certificate with fields needed to decide if it is valid for a principal.
+type Certificate struct {
+	Key          []byte // certified public key
+	Serial       uint64
+	CertType     uint32
+	KeyID        string
+	Principals   []string
+	ValidAfter   uint64
+	ValidBefore  uint64
+	SignatureKey []byte // CA public key
+	Signature    []byte
+
+	signed []byte // part of certificate covered by signature
+}
+
+// IsCertificate reports if wire encoded key is a certificate.
+func IsCertificate(blob []byte) bool {
+	t, err := KeyType(blob)
+	if err != nil {
+		return false
+	}
+	_, ok := certKeys[t]
+	return ok
+}
+
+// ParseCertificate decodes wire encoded certificate. Signature is not verified, see CheckSignature.
+func ParseCertificate(blob []byte) (*Certificate, error) {
+
+	key, err := PlainKey(blob)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := KeyType(blob)
+	if _, ok := certKeys[t]; !ok {
+		return nil, fmt.Errorf("%s is not a certificate", t)
+	}
+
+	r := &Reader{buf: blob}
+	r.Text()
+	r.Bytes() // nonce
+	for i := 0; i < certKeys[t].fields; i++ {
+		r.Bytes()
+	}
+	c := &Certificate{Key: key}
+	c.Serial = r.Uint64()
+	c.CertType = r.Uint32()
+	c.KeyID = r.Text()
+	p := &Reader{buf: r.Bytes()}
+	for p.err == nil && len(p.buf) > 0 {
+		c.Principals = append(c.Principals, p.Text())
+	}
+	c.ValidAfter = r.Uint64()
+	c.ValidBefore = r.Uint64()
+	r.Bytes() // critical options
+	r.Bytes() // extensions
+	r.Bytes() // reserved
+	c.SignatureKey = r.Bytes()
+	c.signed = blob[:len(blob)-len(r.buf)]
+	c.Signature = r.Bytes()
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("bad %s certificate: %w", t, err)
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("bad %s certificate principals: %w", t, p.err)
+	}
+	return c, nil
+}
+
+// ValidFor reports if certificate of given type is valid now for principal. Empty list of principals allows any.
+func (c *Certificate) ValidFor(certType uint32, principal string, now time.Time) bool {
+	if c.CertType != certType {
+		return false
+	}
+	ts := uint64(now.Unix())
+	if ts < c.ValidAfter || ts >= c.ValidBefore {
+		return false
+	}
+	if len(c.Principals) == 0 {
+		return true
+	}
+	for _, p := range c.Principals {
+		if p == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSignature verifies that certificate was signed by SignatureKey.
+func (c *Certificate) CheckSignature() error {
+	return Verify(c.SignatureKey, c.signed, c.Signature)
+}
@@ -0,0 +1,186 @@
+package agentproto
+
+import (
+	"fmt"
+)
+
+// ConstraintType identifies key constraint.
+type ConstraintType byte
+
+// Key constraints.
+const (
+	ConstrainLifetime  ConstraintType = 1
+	ConstrainConfirm   ConstraintType = 2
+	ConstrainMaxSign   ConstraintType = 3
+	ConstrainExtension ConstraintType = 255
+)
+
+// Known constraint extensions.
+const (
+	ExtRestrictDestination = "restrict-destination-v00@openssh.com"
+	ExtSKProvider          = "sk-provider@openssh.com"
+	ExtAssociatedCerts     = "associated-certs-v00@openssh.com"
+)
+
+// Constraint limits key usage, it could be attached to added identities.
+type Constraint interface {
+	Type() ConstraintType
+	marshal(b []byte) []byte
+}
+
+// LifetimeConstraint asks agent to remove key after specified number of seconds.
+type LifetimeConstraint struct {
+	Seconds uint32
+}
+
+// Type returns ConstrainLifetime.
+func (LifetimeConstraint) Type() ConstraintType { return ConstrainLifetime }
+
+func (c LifetimeConstraint) marshal(b []byte) []byte {
+	return AppendUint32(append(b, byte(ConstrainLifetime)), c.Seconds)
+}
+
+// ConfirmConstraint asks agent to confirm every key use with the user.
+type ConfirmConstraint struct{}
+
+// Type returns ConstrainConfirm.
+func (ConfirmConstraint) Type() ConstraintType { return ConstrainConfirm }
+
+func (ConfirmConstraint) marshal(b []byte) []byte {
+	return append(b, byte(ConstrainConfirm))
+}
+
+// MaxSignConstraint limits number of signatures key could produce (XMSS keys).
+type MaxSignConstraint struct {
+	Signatures uint32
+}
+
+// Type returns ConstrainMaxSign.
+func (MaxSignConstraint) Type() ConstraintType { return ConstrainMaxSign }
+
+func (c MaxSignConstraint) marshal(b []byte) []byte {
+	return AppendUint32(append(b, byte(ConstrainMaxSign)), c.Signatures)
+}
+
+// ExtensionConstraint is vendor constraint. Data holds wire encoded extension specific content.
+type ExtensionConstraint struct {
+	Name string
+	Data []byte
+}
+
+// Type returns ConstrainExtension.
+func (ExtensionConstraint) Type() ConstraintType { return ConstrainExtension }
+
+func (c ExtensionConstraint) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(ConstrainExtension)), c.Name)
+	return append(b, c.Data...)
+}
+
+// Extension constraint content is not length prefixed, so we have to know layout of every extension to find where it ends.
+func parseExtensionConstraint(r *Reader) Constraint {
+	name := r.Text()
+	if r.err != nil {
+		return nil
+	}
+	start := r.buf
+	switch name {
+	case ExtRestrictDestination, ExtSKProvider:
+		r.Bytes()
+	case ExtAssociatedCerts:
+		r.Bool()
+		r.Bytes()
+	default:
+		r.fail(fmt.Errorf("unsupported constraint extension %q", name))
+	}
+	if r.err != nil {
+		return nil
+	}
+	return ExtensionConstraint{Name: name, Data: start[:len(start)-len(r.buf)]}
+}
+
+func parseConstraints(r *Reader) []Constraint {
+	var res []Constraint
+	for r.err == nil && len(r.buf) > 0 {
+		switch t := ConstraintType(r.Byte()); t {
+		case ConstrainLifetime:
+			res = append(res, LifetimeConstraint{Seconds: r.Uint32()})
+		case ConstrainConfirm:
+			res = append(res, ConfirmConstraint{})
+		case ConstrainMaxSign:
+			res = append(res, MaxSignConstraint{Signatures: r.Uint32()})
+		case ConstrainExtension:
+			if c := parseExtensionConstraint(r); c != nil {
+				res = append(res, c)
+			}
+		default:
+			r.fail(fmt.Errorf("unknown key constraint %d", t))
+		}
+	}
+	return res
+}
+
+func marshalConstraints(b []byte, cs []Constraint) []byte {
+	for _, c := range cs {
+		b = c.marshal(b)
+	}
+	return b
+}
+
+// HostKey is host key (or certificate authority key when CA is set) of a destination constraint hop.
+type HostKey struct {
+	Blob []byte
+	CA   bool
+}
+
+// Hop describes one side of destination constraint. Empty Hostname of "from" hop means origin - the host where key
+// was added.
+type Hop struct {
+	User     string
+	Hostname string
+	Keys     []HostKey
+}
+
+// DestinationConstraint permits key use for connections from one host to another, as set by ssh-add -h.
+type DestinationConstraint struct {
+	From, To Hop
+}
+
+// ParseDestinationConstraints decodes restrict-destination-v00@openssh.com key constraint.
+func ParseDestinationConstraints(c ExtensionConstraint) ([]DestinationConstraint, error) {
+
+	if c.Name != ExtRestrictDestination {
+		return nil, fmt.Errorf("unexpected constraint extension %q", c.Name)
+	}
+	outer := &Reader{buf: c.Data}
+	r := &Reader{buf: outer.Bytes()}
+	if err := outer.Done(); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", c.Name, err)
+	}
+	var res []DestinationConstraint
+	for r.err == nil && len(r.buf) > 0 {
+		dr := &Reader{buf: r.Bytes()}
+		dc := DestinationConstraint{From: parseHop(dr), To: parseHop(dr)}
+		dr.Bytes() // reserved
+		if err := dr.Done(); err != nil {
+			return nil, fmt.Errorf("malformed %s: %w", c.Name, err)
+		}
+		res = append(res, dc)
+	}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", c.Name, err)
+	}
+	return res, nil
+}
+
+func parseHop(dr *Reader) Hop {
+	r := &Reader{buf: dr.Bytes()}
+	h := Hop{User: r.Text(), Hostname: r.Text()}
+	r.Bytes() // reserved
+	for r.err == nil && len(r.buf) > 0 {
+		h.Keys = append(h.Keys, HostKey{Blob: r.Bytes(), CA: r.Bool()})
+	}
+	if err := r.Done(); err != nil {
+		dr.fail(err)
+	}
+	return h
+}
@@ -0,0 +1,111 @@
+package agentproto
+
+import (
+	"fmt"
+)
+
+// Well known agent extensions.
+const (
+	ExtQuery       = "query"
+	ExtSessionBind = "session-bind@openssh.com"
+)
+
+// Extension is SSH_AGENTC_EXTENSION request. Contents is extension specific.
+type Extension struct {
+	Name     string
+	Contents []byte
+}
+
+// Type returns ExtensionMsg.
+func (*Extension) Type() MessageType { return ExtensionMsg }
+
+func (m *Extension) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(ExtensionMsg)), m.Name)
+	return append(b, m.Contents...)
+}
+
+// ExtensionResponse is SSH_AGENT_EXTENSION_RESPONSE reply. Contents is extension specific.
+type ExtensionResponse struct {
+	Name     string
+	Contents []byte
+}
+
+// Type returns ExtensionResponseMsg.
+func (*ExtensionResponse) Type() MessageType { return ExtensionResponseMsg }
+
+func (m *ExtensionResponse) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(ExtensionResponseMsg)), m.Name)
+	return append(b, m.Contents...)
+}
+
+// SessionBind is content of session-bind@openssh.com extension: ssh client binds agent connection to the server host
+// key and session identifier it has authenticated.
+type SessionBind struct {
+	HostKey    []byte
+	SessionID  []byte
+	Signature  []byte
+	Forwarding bool
+}
+
+// ParseSessionBind decodes session-bind@openssh.com extension request.
+func ParseSessionBind(m *Extension) (*SessionBind, error) {
+	if m.Name != ExtSessionBind {
+		return nil, fmt.Errorf("unexpected extension %q", m.Name)
+	}
+	r := &Reader{buf: m.Contents}
+	sb := &SessionBind{HostKey: r.Bytes(), SessionID: r.Bytes(), Signature: r.Bytes(), Forwarding: r.Bool()}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", ExtSessionBind, err)
+	}
+	return sb, nil
+}
+
+// Extension encodes session binding as extension request.
+func (sb *SessionBind) Extension() *Extension {
+	b := AppendBytes(nil, sb.HostKey)
+	b = AppendBytes(b, sb.SessionID)
+	b = AppendBytes(b, sb.Signature)
+	return &Extension{Name: ExtSessionBind, Contents: AppendBool(b, sb.Forwarding)}
+}
+
+// ParseQueryResponse returns list of extensions from reply to "query" extension. Both SSH_AGENT_EXTENSION_RESPONSE
+// and older SSH_AGENT_SUCCESS forms are accepted.
+func ParseQueryResponse(m Message) ([]string, error) {
+	var contents []byte
+	switch msg := m.(type) {
+	case *ExtensionResponse:
+		if msg.Name != ExtQuery {
+			return nil, fmt.Errorf("unexpected extension response %q", msg.Name)
+		}
+		contents = msg.Contents
+	case *Success:
+		r := &Reader{buf: msg.Contents}
+		if name := r.Text(); r.err == nil && name != ExtQuery {
+			return nil, fmt.Errorf("unexpected extension response %q", name)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("malformed query response: %w", r.err)
+		}
+		contents = r.buf
+	default:
+		return nil, fmt.Errorf("unexpected query response %s", m.Type())
+	}
+	r := &Reader{buf: contents}
+	var names []string
+	for r.err == nil && len(r.buf) > 0 {
+		names = append(names, r.Text())
+	}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed query response: %w", err)
+	}
+	return names, nil
+}
+
+// QueryResponse builds reply to "query" extension listing supported extensions.
+func QueryResponse(names []string) *ExtensionResponse {
+	var b []byte
+	for _, n := range names {
+		b = AppendString(b, n)
+	}
+	return &ExtensionResponse{Name: ExtQuery, Contents: b}
+}
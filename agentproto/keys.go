@@ -0,0 +1,164 @@
+package agentproto
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Private key content of ADD_IDENTITY is not length prefixed and depends on key type. Layouts below describe
+// sequence of wire fields following key type string for every key type OpenSSH knows about: 's' is string or mpint,
+// 'b' is single byte.
+var keyLayouts = map[string]string{
+	"ssh-rsa":                                     "ssssss", // n, e, d, iqmp, p, q
+	"ssh-dss":                                     "sssss",  // p, q, g, y, x
+	"ecdsa-sha2-nistp256":                         "sss",    // curve, Q, d
+	"ecdsa-sha2-nistp384":                         "sss",    // curve, Q, d
+	"ecdsa-sha2-nistp521":                         "sss",    // curve, Q, d
+	"ssh-ed25519":                                 "ss",     // public, private || public
+	"sk-ecdsa-sha2-nistp256@openssh.com":          "sssbss", // curve, Q, application, flags, key handle, reserved
+	"sk-ssh-ed25519@openssh.com":                  "ssbss",  // public, application, flags, key handle, reserved
+	"ssh-rsa-cert-v01@openssh.com":                "sssss",  // certificate, d, iqmp, p, q
+	"ssh-dss-cert-v01@openssh.com":                "ss",     // certificate, x
+	"ecdsa-sha2-nistp256-cert-v01@openssh.com":    "ss",     // certificate, d
+	"ecdsa-sha2-nistp384-cert-v01@openssh.com":    "ss",     // certificate, d
+	"ecdsa-sha2-nistp521-cert-v01@openssh.com":    "ss",     // certificate, d
+	"ssh-ed25519-cert-v01@openssh.com":            "sss",    // certificate, public, private || public
+	"sk-ecdsa-sha2-nistp256-cert-v01@openssh.com": "sbss",   // certificate, flags, key handle, reserved
+	"sk-ssh-ed25519-cert-v01@openssh.com":         "sbss",   // certificate, flags, key handle, reserved
+}
+
+// parseKeyFields consumes private key content of specified type and returns it as raw wire bytes.
+func parseKeyFields(r *Reader, keyType string) []byte {
+	layout, ok := keyLayouts[keyType]
+	if !ok {
+		r.fail(fmt.Errorf("unsupported key type %q", keyType))
+		return nil
+	}
+	start := r.buf
+	for _, f := range layout {
+		switch f {
+		case 's':
+			r.Bytes()
+		case 'b':
+			r.Byte()
+		}
+	}
+	if r.err != nil {
+		return nil
+	}
+	return start[:len(start)-len(r.buf)]
+}
+
+// KeyFields splits private key content of added identity into separate fields according to key type layout. Single
+// byte fields are returned as one byte slices, strings and mpints are returned without length prefix.
+func (m *AddIdentity) KeyFields() ([][]byte, error) {
+	layout, ok := keyLayouts[m.KeyType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %q", m.KeyType)
+	}
+	r := &Reader{buf: m.Key}
+	res := make([][]byte, 0, len(layout))
+	for _, f := range layout {
+		switch f {
+		case 's':
+			res = append(res, r.Bytes())
+		case 'b':
+			res = append(res, []byte{r.Byte()})
+		}
+	}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("bad %s key: %w", m.KeyType, err)
+	}
+	return res, nil
+}
+
+// Certificates carry public key fields right after certificate type and nonce. Number of these fields and type of
+// plain key they form are listed below.
+var certKeys = map[string]struct {
+	keyType string
+	fields  int
+}{
+	"ssh-rsa-cert-v01@openssh.com":                {"ssh-rsa", 2},                            // e, n
+	"ssh-dss-cert-v01@openssh.com":                {"ssh-dss", 4},                            // p, q, g, y
+	"ecdsa-sha2-nistp256-cert-v01@openssh.com":    {"ecdsa-sha2-nistp256", 2},                // curve, Q
+	"ecdsa-sha2-nistp384-cert-v01@openssh.com":    {"ecdsa-sha2-nistp384", 2},                // curve, Q
+	"ecdsa-sha2-nistp521-cert-v01@openssh.com":    {"ecdsa-sha2-nistp521", 2},                // curve, Q
+	"ssh-ed25519-cert-v01@openssh.com":            {"ssh-ed25519", 1},                        // public
+	"sk-ecdsa-sha2-nistp256-cert-v01@openssh.com": {"sk-ecdsa-sha2-nistp256@openssh.com", 3}, // curve, Q, application
+	"sk-ssh-ed25519-cert-v01@openssh.com":         {"sk-ssh-ed25519@openssh.com", 2},         // public, application
+}
+
+// KeyType returns type name of wire encoded public key or certificate.
+func KeyType(blob []byte) (string, error) {
+	r := &Reader{buf: blob}
+	t := r.Text()
+	if r.err != nil {
+		return "", fmt.Errorf("bad key blob: %w", r.err)
+	}
+	return t, nil
+}
+
+// PlainKey returns public key certified by wire encoded certificate. Plain public keys are returned as is.
+func PlainKey(blob []byte) ([]byte, error) {
+	t, err := KeyType(blob)
+	if err != nil {
+		return nil, err
+	}
+	ck, ok := certKeys[t]
+	if !ok {
+		return blob, nil
+	}
+	r := &Reader{buf: blob}
+	r.Text()
+	r.Bytes() // nonce
+	start := r.buf
+	for i := 0; i < ck.fields; i++ {
+		r.Bytes()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("bad %s certificate: %w", t, r.err)
+	}
+	return append(AppendString(nil, ck.keyType), start[:len(start)-len(r.buf)]...), nil
+}
+
+// Fingerprint returns SHA256 fingerprint of public key in the form ssh-keygen -l prints it. Certificates are
+// fingerprinted by the key they certify.
+func Fingerprint(blob []byte) string {
+	if k, err := PlainKey(blob); err == nil {
+		blob = k
+	}
+	h := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(h[:])
+}
+
+// PublicKey returns wire encoded public key (or certificate) of added identity.
+func (m *AddIdentity) PublicKey() ([]byte, error) {
+	f, err := m.KeyFields()
+	if err != nil {
+		return nil, err
+	}
+	var pub [][]byte
+	switch m.KeyType {
+	case "ssh-rsa":
+		pub = [][]byte{f[1], f[0]} // e, n
+	case "ssh-dss":
+		pub = f[:4] // p, q, g, y
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		pub = f[:2] // curve, Q
+	case "sk-ecdsa-sha2-nistp256@openssh.com":
+		pub = f[:3] // curve, Q, application
+	case "ssh-ed25519":
+		pub = f[:1]
+	case "sk-ssh-ed25519@openssh.com":
+		pub = f[:2] // public, application
+	default:
+		// certificates come first
+		return f[0], nil
+	}
+	b := AppendString(nil, m.KeyType)
+	for _, v := range pub {
+		b = AppendBytes(b, v)
+	}
+	return b, nil
+}
@@ -0,0 +1,371 @@
+// Package agentproto parses and marshals ssh-agent protocol messages (draft-miller-ssh-agent).
+//
+// Parse and Marshal operate on message payload without 4 bytes length prefix. Any message produced by Parse marshals
+// back to exactly the same bytes.
+package agentproto
+
+import (
+	"fmt"
+)
+
+// MessageType is the first byte of every agent message.
+type MessageType byte
+
+// Requests and replies.
+const (
+	AgentFailure           MessageType = 5
+	AgentSuccess           MessageType = 6
+	RequestIdentitiesMsg   MessageType = 11
+	IdentitiesAnswerMsg    MessageType = 12
+	SignRequestMsg         MessageType = 13
+	SignResponseMsg        MessageType = 14
+	AddIdentityMsg         MessageType = 17
+	RemoveIdentityMsg      MessageType = 18
+	RemoveAllIdentitiesMsg MessageType = 19
+	AddSmartcardKeyMsg     MessageType = 20
+	RemoveSmartcardKeyMsg  MessageType = 21
+	LockMsg                MessageType = 22
+	UnlockMsg              MessageType = 23
+	AddIDConstrainedMsg    MessageType = 25
+	AddSmartcardKeyConsMsg MessageType = 26
+	ExtensionMsg           MessageType = 27
+	ExtensionFailureMsg    MessageType = 28
+	ExtensionResponseMsg   MessageType = 29
+)
+
+var typeNames = map[MessageType]string{
+	AgentFailure:           "SSH_AGENT_FAILURE",
+	AgentSuccess:           "SSH_AGENT_SUCCESS",
+	RequestIdentitiesMsg:   "SSH_AGENTC_REQUEST_IDENTITIES",
+	IdentitiesAnswerMsg:    "SSH_AGENT_IDENTITIES_ANSWER",
+	SignRequestMsg:         "SSH_AGENTC_SIGN_REQUEST",
+	SignResponseMsg:        "SSH_AGENT_SIGN_RESPONSE",
+	AddIdentityMsg:         "SSH_AGENTC_ADD_IDENTITY",
+	RemoveIdentityMsg:      "SSH_AGENTC_REMOVE_IDENTITY",
+	RemoveAllIdentitiesMsg: "SSH_AGENTC_REMOVE_ALL_IDENTITIES",
+	AddSmartcardKeyMsg:     "SSH_AGENTC_ADD_SMARTCARD_KEY",
+	RemoveSmartcardKeyMsg:  "SSH_AGENTC_REMOVE_SMARTCARD_KEY",
+	LockMsg:                "SSH_AGENTC_LOCK",
+	UnlockMsg:              "SSH_AGENTC_UNLOCK",
+	AddIDConstrainedMsg:    "SSH_AGENTC_ADD_ID_CONSTRAINED",
+	AddSmartcardKeyConsMsg: "SSH_AGENTC_ADD_SMARTCARD_KEY_CONSTRAINED",
+	ExtensionMsg:           "SSH_AGENTC_EXTENSION",
+	ExtensionFailureMsg:    "SSH_AGENT_EXTENSION_FAILURE",
+	ExtensionResponseMsg:   "SSH_AGENT_EXTENSION_RESPONSE",
+}
+
+func (t MessageType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN (%d)", byte(t))
+}
+
+// SignFlags modify SIGN_REQUEST behavior.
+type SignFlags uint32
+
+// Signature flags.
+const (
+	SignOldSignature SignFlags = 1
+	SignRSASHA256    SignFlags = 2
+	SignRSASHA512    SignFlags = 4
+)
+
+// Message is a parsed agent request or reply.
+type Message interface {
+	Type() MessageType
+	marshal(b []byte) []byte
+}
+
+// Marshal returns wire payload of the message without length prefix.
+func Marshal(m Message) []byte {
+	return m.marshal(nil)
+}
+
+// Parse decodes message payload (without length prefix). Messages of unknown type are returned as *Unknown.
+func Parse(payload []byte) (Message, error) {
+
+	if len(payload) == 0 {
+		return nil, ErrEmptyMessage
+	}
+
+	t := MessageType(payload[0])
+	r := &Reader{buf: payload[1:]}
+
+	var m Message
+	switch t {
+	case AgentFailure:
+		m = &Failure{}
+	case AgentSuccess:
+		m = &Success{Contents: r.Rest()}
+	case RequestIdentitiesMsg:
+		m = &RequestIdentities{}
+	case IdentitiesAnswerMsg:
+		m = parseIdentitiesAnswer(r)
+	case SignRequestMsg:
+		m = &SignRequest{KeyBlob: r.Bytes(), Data: r.Bytes(), Flags: SignFlags(r.Uint32())}
+	case SignResponseMsg:
+		m = &SignResponse{Signature: r.Bytes()}
+	case AddIdentityMsg, AddIDConstrainedMsg:
+		m = parseAddIdentity(r, t == AddIDConstrainedMsg)
+	case RemoveIdentityMsg:
+		m = &RemoveIdentity{KeyBlob: r.Bytes()}
+	case RemoveAllIdentitiesMsg:
+		m = &RemoveAllIdentities{}
+	case AddSmartcardKeyMsg, AddSmartcardKeyConsMsg:
+		msg := &AddSmartcardKey{ID: r.Text(), PIN: r.Text(), Constrained: t == AddSmartcardKeyConsMsg}
+		if msg.Constrained {
+			msg.Constraints = parseConstraints(r)
+		}
+		m = msg
+	case RemoveSmartcardKeyMsg:
+		m = &RemoveSmartcardKey{ID: r.Text(), PIN: r.Text()}
+	case LockMsg:
+		m = &Lock{Passphrase: r.Bytes()}
+	case UnlockMsg:
+		m = &Unlock{Passphrase: r.Bytes()}
+	case ExtensionMsg:
+		m = &Extension{Name: r.Text(), Contents: r.Rest()}
+	case ExtensionFailureMsg:
+		m = &ExtensionFailure{}
+	case ExtensionResponseMsg:
+		m = &ExtensionResponse{Name: r.Text(), Contents: r.Rest()}
+	default:
+		m = &Unknown{Kind: t, Payload: r.Rest()}
+	}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", t, err)
+	}
+	return m, nil
+}
+
+// Failure is SSH_AGENT_FAILURE reply.
+type Failure struct{}
+
+// Type returns AgentFailure.
+func (*Failure) Type() MessageType { return AgentFailure }
+
+func (*Failure) marshal(b []byte) []byte { return append(b, byte(AgentFailure)) }
+
+// Success is SSH_AGENT_SUCCESS reply. Some agents append extension specific data to it.
+type Success struct {
+	Contents []byte
+}
+
+// Type returns AgentSuccess.
+func (*Success) Type() MessageType { return AgentSuccess }
+
+func (m *Success) marshal(b []byte) []byte {
+	return append(append(b, byte(AgentSuccess)), m.Contents...)
+}
+
+// RequestIdentities is SSH_AGENTC_REQUEST_IDENTITIES request.
+type RequestIdentities struct{}
+
+// Type returns RequestIdentitiesMsg.
+func (*RequestIdentities) Type() MessageType { return RequestIdentitiesMsg }
+
+func (*RequestIdentities) marshal(b []byte) []byte { return append(b, byte(RequestIdentitiesMsg)) }
+
+// Identity is a public key with comment as listed by agent.
+type Identity struct {
+	KeyBlob []byte
+	Comment string
+}
+
+// IdentitiesAnswer is SSH_AGENT_IDENTITIES_ANSWER reply.
+type IdentitiesAnswer struct {
+	Keys []Identity
+}
+
+// Type returns IdentitiesAnswerMsg.
+func (*IdentitiesAnswer) Type() MessageType { return IdentitiesAnswerMsg }
+
+func (m *IdentitiesAnswer) marshal(b []byte) []byte {
+	b = AppendUint32(append(b, byte(IdentitiesAnswerMsg)), uint32(len(m.Keys)))
+	for _, k := range m.Keys {
+		b = AppendBytes(b, k.KeyBlob)
+		b = AppendString(b, k.Comment)
+	}
+	return b
+}
+
+func parseIdentitiesAnswer(r *Reader) *IdentitiesAnswer {
+	n := r.Uint32()
+	// every identity takes at least 8 bytes, do not trust count blindly
+	if r.err == nil && uint64(n)*8 > uint64(len(r.buf)) {
+		r.fail(fmt.Errorf("bad number of identities %d: %w", n, ErrShortMessage))
+	}
+	if r.err != nil {
+		return &IdentitiesAnswer{}
+	}
+	m := &IdentitiesAnswer{Keys: make([]Identity, 0, n)}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		m.Keys = append(m.Keys, Identity{KeyBlob: r.Bytes(), Comment: r.Text()})
+	}
+	return m
+}
+
+// SignRequest is SSH_AGENTC_SIGN_REQUEST request.
+type SignRequest struct {
+	KeyBlob []byte
+	Data    []byte
+	Flags   SignFlags
+}
+
+// Type returns SignRequestMsg.
+func (*SignRequest) Type() MessageType { return SignRequestMsg }
+
+func (m *SignRequest) marshal(b []byte) []byte {
+	b = AppendBytes(append(b, byte(SignRequestMsg)), m.KeyBlob)
+	b = AppendBytes(b, m.Data)
+	return AppendUint32(b, uint32(m.Flags))
+}
+
+// SignResponse is SSH_AGENT_SIGN_RESPONSE reply.
+type SignResponse struct {
+	Signature []byte
+}
+
+// Type returns SignResponseMsg.
+func (*SignResponse) Type() MessageType { return SignResponseMsg }
+
+func (m *SignResponse) marshal(b []byte) []byte {
+	return AppendBytes(append(b, byte(SignResponseMsg)), m.Signature)
+}
+
+// AddIdentity is SSH_AGENTC_ADD_IDENTITY or, when Constrained is set, SSH_AGENTC_ADD_ID_CONSTRAINED request. Key holds
+// wire encoded private key content which follows key type, use KeyFields to access individual fields.
+type AddIdentity struct {
+	KeyType     string
+	Key         []byte
+	Comment     string
+	Constrained bool
+	Constraints []Constraint
+}
+
+// Type returns AddIdentityMsg or AddIDConstrainedMsg.
+func (m *AddIdentity) Type() MessageType {
+	if m.Constrained {
+		return AddIDConstrainedMsg
+	}
+	return AddIdentityMsg
+}
+
+func (m *AddIdentity) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(m.Type())), m.KeyType)
+	b = append(b, m.Key...)
+	b = AppendString(b, m.Comment)
+	return marshalConstraints(b, m.Constraints)
+}
+
+func parseAddIdentity(r *Reader, constrained bool) *AddIdentity {
+	m := &AddIdentity{KeyType: r.Text(), Constrained: constrained}
+	if r.err != nil {
+		return m
+	}
+	m.Key = parseKeyFields(r, m.KeyType)
+	m.Comment = r.Text()
+	if constrained {
+		m.Constraints = parseConstraints(r)
+	}
+	return m
+}
+
+// RemoveIdentity is SSH_AGENTC_REMOVE_IDENTITY request.
+type RemoveIdentity struct {
+	KeyBlob []byte
+}
+
+// Type returns RemoveIdentityMsg.
+func (*RemoveIdentity) Type() MessageType { return RemoveIdentityMsg }
+
+func (m *RemoveIdentity) marshal(b []byte) []byte {
+	return AppendBytes(append(b, byte(RemoveIdentityMsg)), m.KeyBlob)
+}
+
+// RemoveAllIdentities is SSH_AGENTC_REMOVE_ALL_IDENTITIES request.
+type RemoveAllIdentities struct{}
+
+// Type returns RemoveAllIdentitiesMsg.
+func (*RemoveAllIdentities) Type() MessageType { return RemoveAllIdentitiesMsg }
+
+func (*RemoveAllIdentities) marshal(b []byte) []byte { return append(b, byte(RemoveAllIdentitiesMsg)) }
+
+// AddSmartcardKey is SSH_AGENTC_ADD_SMARTCARD_KEY or, when Constrained is set, SSH_AGENTC_ADD_SMARTCARD_KEY_CONSTRAINED
+// request.
+type AddSmartcardKey struct {
+	ID          string
+	PIN         string
+	Constrained bool
+	Constraints []Constraint
+}
+
+// Type returns AddSmartcardKeyMsg or AddSmartcardKeyConsMsg.
+func (m *AddSmartcardKey) Type() MessageType {
+	if m.Constrained {
+		return AddSmartcardKeyConsMsg
+	}
+	return AddSmartcardKeyMsg
+}
+
+func (m *AddSmartcardKey) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(m.Type())), m.ID)
+	b = AppendString(b, m.PIN)
+	return marshalConstraints(b, m.Constraints)
+}
+
+// RemoveSmartcardKey is SSH_AGENTC_REMOVE_SMARTCARD_KEY request.
+type RemoveSmartcardKey struct {
+	ID  string
+	PIN string
+}
+
+// Type returns RemoveSmartcardKeyMsg.
+func (*RemoveSmartcardKey) Type() MessageType { return RemoveSmartcardKeyMsg }
+
+func (m *RemoveSmartcardKey) marshal(b []byte) []byte {
+	b = AppendString(append(b, byte(RemoveSmartcardKeyMsg)), m.ID)
+	return AppendString(b, m.PIN)
+}
+
+// Lock is SSH_AGENTC_LOCK request.
+type Lock struct {
+	Passphrase []byte
+}
+
+// Type returns LockMsg.
+func (*Lock) Type() MessageType { return LockMsg }
+
+func (m *Lock) marshal(b []byte) []byte { return AppendBytes(append(b, byte(LockMsg)), m.Passphrase) }
+
+// Unlock is SSH_AGENTC_UNLOCK request.
+type Unlock struct {
+	Passphrase []byte
+}
+
+// Type returns UnlockMsg.
+func (*Unlock) Type() MessageType { return UnlockMsg }
+
+func (m *Unlock) marshal(b []byte) []byte {
+	return AppendBytes(append(b, byte(UnlockMsg)), m.Passphrase)
+}
+
+// ExtensionFailure is SSH_AGENT_EXTENSION_FAILURE reply.
+type ExtensionFailure struct{}
+
+// Type returns ExtensionFailureMsg.
+func (*ExtensionFailure) Type() MessageType { return ExtensionFailureMsg }
+
+func (*ExtensionFailure) marshal(b []byte) []byte { return append(b, byte(ExtensionFailureMsg)) }
+
+// Unknown holds message of type this package does not understand.
+type Unknown struct {
+	Kind    MessageType
+	Payload []byte
+}
+
+// Type returns message type.
+func (m *Unknown) Type() MessageType { return m.Kind }
+
+func (m *Unknown) marshal(b []byte) []byte { return append(append(b, byte(m.Kind)), m.Payload...) }
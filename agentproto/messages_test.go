@@ -0,0 +1,102 @@
+package agentproto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKeyBlob() []byte {
+	return AppendBytes(AppendString(nil, "ssh-ed25519"), bytes.Repeat([]byte{1}, 32))
+}
+
+func TestRoundTrip(t *testing.T) {
+	ed25519Key := AppendBytes(AppendBytes(nil, bytes.Repeat([]byte{1}, 32)), bytes.Repeat([]byte{2}, 64))
+	destination := AppendBytes(nil, []byte("hops"))
+
+	tests := []struct {
+		name string
+		m    Message
+	}{
+		{name: "failure", m: &Failure{}},
+		{name: "success", m: &Success{}},
+		{name: "success with contents", m: &Success{Contents: []byte{1, 2, 3}}},
+		{name: "request identities", m: &RequestIdentities{}},
+		{name: "no identities", m: &IdentitiesAnswer{}},
+		{name: "identities", m: &IdentitiesAnswer{Keys: []Identity{{KeyBlob: testKeyBlob(), Comment: "c-ed25519"}, {KeyBlob: []byte{}, Comment: ""}}}},
+		{name: "sign request", m: &SignRequest{KeyBlob: testKeyBlob(), Data: []byte("data"), Flags: SignRSASHA512}},
+		{name: "sign response", m: &SignResponse{Signature: []byte("signature")}},
+		{name: "add identity", m: &AddIdentity{KeyType: "ssh-ed25519", Key: ed25519Key, Comment: "c-ed25519"}},
+		{name: "add constrained identity", m: &AddIdentity{KeyType: "ssh-ed25519", Key: ed25519Key, Comment: "c", Constrained: true,
+			Constraints: []Constraint{LifetimeConstraint{Seconds: 60}, ConfirmConstraint{}, MaxSignConstraint{Signatures: 5},
+				ExtensionConstraint{Name: ExtRestrictDestination, Data: destination}}}},
+		{name: "remove identity", m: &RemoveIdentity{KeyBlob: testKeyBlob()}},
+		{name: "remove all", m: &RemoveAllIdentities{}},
+		{name: "add smartcard", m: &AddSmartcardKey{ID: "/usr/lib/pkcs11.so", PIN: "1234"}},
+		{name: "add constrained smartcard", m: &AddSmartcardKey{ID: "id", PIN: "pin", Constrained: true, Constraints: []Constraint{ConfirmConstraint{}}}},
+		{name: "remove smartcard", m: &RemoveSmartcardKey{ID: "id", PIN: "pin"}},
+		{name: "lock", m: &Lock{Passphrase: []byte("secret")}},
+		{name: "unlock", m: &Unlock{Passphrase: []byte("secret")}},
+		{name: "extension", m: &Extension{Name: ExtQuery}},
+		{name: "extension with contents", m: &Extension{Name: ExtSessionBind, Contents: []byte{0, 0, 0, 1, 'x'}}},
+		{name: "extension failure", m: &ExtensionFailure{}},
+		{name: "extension response", m: &ExtensionResponse{Name: ExtQuery, Contents: AppendString(nil, ExtQuery)}},
+		{name: "unknown", m: &Unknown{Kind: 99, Payload: []byte{1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := Marshal(tt.m)
+			if MessageType(payload[0]) != tt.m.Type() {
+				t.Fatalf("payload starts with %s, want %s", MessageType(payload[0]), tt.m.Type())
+			}
+			m, err := Parse(payload)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if m.Type() != tt.m.Type() {
+				t.Errorf("parsed %s, want %s", m.Type(), tt.m.Type())
+			}
+			if got := Marshal(m); !bytes.Equal(got, payload) {
+				t.Errorf("marshalled back to %x, want %x", got, payload)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	sign := Marshal(&SignRequest{KeyBlob: testKeyBlob(), Data: []byte("data")})
+
+	tests := []struct {
+		name    string
+		payload []byte
+		err     error
+	}{
+		{name: "empty", payload: nil, err: ErrEmptyMessage},
+		{name: "truncated", payload: sign[:len(sign)-1], err: ErrShortMessage},
+		{name: "trailing data", payload: append(append([]byte{}, sign...), 0), err: ErrTrailingData},
+		{name: "string longer than message", payload: []byte{byte(RemoveIdentityMsg), 0, 0, 1, 0, 1}, err: ErrShortMessage},
+		{name: "too many identities", payload: []byte{byte(IdentitiesAnswerMsg), 0xff, 0xff, 0xff, 0xff}, err: ErrShortMessage},
+		{name: "unknown key type", payload: Marshal(&AddIdentity{KeyType: "ssh-foo"})},
+		{name: "unknown constraint", payload: append(Marshal(&AddIdentity{KeyType: "ssh-ed25519", Key: AppendBytes(AppendBytes(nil, nil), nil), Constrained: true}), 7)},
+		{name: "unknown constraint extension", payload: Marshal(&AddSmartcardKey{Constrained: true, Constraints: []Constraint{ExtensionConstraint{Name: "foo@example.com"}}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.payload)
+			if err == nil {
+				t.Fatalf("Parse(%x) = %s, want error", tt.payload, m.Type())
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("Parse(%x) error %q, want %q", tt.payload, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	blob := AppendBytes(AppendString(nil, "ssh-ed25519"), make([]byte, 32))
+	// as printed by ssh-keygen -l
+	if got, want := Fingerprint(blob), "SHA256:kmYcvdi2GkPeWxB6XLjrZB8JHsy2Hm8luHMFp9GMvqk"; got != want {
+		t.Errorf("Fingerprint = %q, want %q", got, want)
+	}
+}
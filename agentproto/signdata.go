@@ -0,0 +1,124 @@
+package agentproto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Public key authentication methods.
+const (
+	MethodPublicKey          = "publickey"
+	MethodPublicKeyHostbound = "publickey-hostbound-v00@openssh.com"
+)
+
+const msgUserauthRequest = 50
+
+// UserauthRequest is data ssh client signs for public key user authentication.
+type UserauthRequest struct {
+	SessionID []byte
+	User      string
+	Service   string
+	Method    string
+	Algorithm string
+	Key       []byte
+	HostKey   []byte // server host key, only for hostbound method
+}
+
+// ParseUserauthRequest decodes data of sign request as public key user authentication request.
+func ParseUserauthRequest(data []byte) (*UserauthRequest, error) {
+
+	r := &Reader{buf: data}
+	u := &UserauthRequest{SessionID: r.Bytes()}
+	if t := r.Byte(); r.err == nil && t != msgUserauthRequest {
+		return nil, fmt.Errorf("not a userauth request: message type %d", t)
+	}
+	u.User, u.Service, u.Method = r.Text(), r.Text(), r.Text()
+	if r.err == nil && u.Method != MethodPublicKey && u.Method != MethodPublicKeyHostbound {
+		return nil, fmt.Errorf("unexpected userauth method %q", u.Method)
+	}
+	if !r.Bool() && r.err == nil {
+		return nil, errors.New("userauth request without signature")
+	}
+	u.Algorithm, u.Key = r.Text(), r.Bytes()
+	if u.Method == MethodPublicKeyHostbound {
+		u.HostKey = r.Bytes()
+	}
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed userauth request: %w", err)
+	}
+	return u, nil
+}
+
+const sshsigMagic = "SSHSIG"
+
+// SSHSig is data ssh-keygen -Y sign (and git using it) signs: hash of the message in a namespace.
+type SSHSig struct {
+	Namespace     string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// ParseSSHSig decodes data of sign request as SSHSIG signed data.
+func ParseSSHSig(data []byte) (*SSHSig, error) {
+
+	if len(data) < len(sshsigMagic) || string(data[:len(sshsigMagic)]) != sshsigMagic {
+		return nil, errors.New("not a SSHSIG data")
+	}
+	r := &Reader{buf: data[len(sshsigMagic):]}
+	s := &SSHSig{Namespace: r.Text()}
+	r.Bytes() // reserved
+	s.HashAlgorithm, s.Hash = r.Text(), r.Bytes()
+	if err := r.Done(); err != nil {
+		return nil, fmt.Errorf("malformed SSHSIG data: %w", err)
+	}
+	return s, nil
+}
+
+// Kinds of signed data.
+const (
+	SignedUserauth = "userauth"
+	SignedSSHSig   = "sshsig"
+	SignedUnknown  = "unknown"
+)
+
+// SignedData summarizes what sign request is for. Only fields relevant to Kind are set.
+type SignedData struct {
+	Kind string
+	Size int
+
+	// user authentication
+	User      string
+	Service   string
+	SessionID []byte
+	HostKey   []byte // hostbound authentication only
+
+	// SSHSIG
+	Namespace     string
+	HashAlgorithm string
+}
+
+// ParseSignedData recognizes data of sign request. Data which could not be decoded is reported as SignedUnknown.
+func ParseSignedData(data []byte) *SignedData {
+	d := &SignedData{Kind: SignedUnknown, Size: len(data)}
+	if u, err := ParseUserauthRequest(data); err == nil {
+		d.Kind, d.User, d.Service, d.SessionID, d.HostKey = SignedUserauth, u.User, u.Service, u.SessionID, u.HostKey
+	} else if s, err := ParseSSHSig(data); err == nil {
+		d.Kind, d.Namespace, d.HashAlgorithm = SignedSSHSig, s.Namespace, s.HashAlgorithm
+	}
+	return d
+}
+
+// String returns human readable summary.
+func (d *SignedData) String() string {
+	switch d.Kind {
+	case SignedUserauth:
+		s := fmt.Sprintf("authentication of user %q for %s", d.User, d.Service)
+		if d.HostKey != nil {
+			s += " to host " + Fingerprint(d.HostKey)
+		}
+		return s
+	case SignedSSHSig:
+		return fmt.Sprintf("ssh-keygen -Y sign in namespace %q", d.Namespace)
+	}
+	return fmt.Sprintf("%d bytes of unknown data", d.Size)
+}
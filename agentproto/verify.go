@@ -0,0 +1,122 @@
+package agentproto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrBadSignature is returned when signature does not match data.
+var ErrBadSignature = errors.New("signature verification failed")
+
+var verifyCurves = map[string]struct {
+	curve elliptic.Curve
+	hash  crypto.Hash
+}{
+	"nistp256": {elliptic.P256(), crypto.SHA256},
+	"nistp384": {elliptic.P384(), crypto.SHA384},
+	"nistp521": {elliptic.P521(), crypto.SHA512},
+}
+
+// Verify checks wire encoded ssh signature of data made with wire encoded public key. Certificates are verified with
+// the key they certify. Security key types are not supported.
+func Verify(key, data, sig []byte) error {
+
+	key, err := PlainKey(key)
+	if err != nil {
+		return err
+	}
+	kr := &Reader{buf: key}
+	keyType := kr.Text()
+
+	sr := &Reader{buf: sig}
+	format, blob := sr.Text(), sr.Bytes()
+	if err := sr.Done(); err != nil {
+		return fmt.Errorf("bad signature: %w", err)
+	}
+
+	switch keyType {
+	case "ssh-ed25519":
+		pub := kr.Bytes()
+		if err := kr.Done(); err != nil || len(pub) != ed25519.PublicKeySize {
+			return errors.New("bad ssh-ed25519 key")
+		}
+		if format != keyType {
+			return fmt.Errorf("unexpected %s signature for %s key", format, keyType)
+		}
+		if !ed25519.Verify(pub, data, blob) {
+			return ErrBadSignature
+		}
+		return nil
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		curve, q := kr.Text(), kr.Bytes()
+		vc, ok := verifyCurves[curve]
+		if err := kr.Done(); err != nil || !ok || keyType != "ecdsa-sha2-"+curve {
+			return fmt.Errorf("bad %s key", keyType)
+		}
+		if format != keyType {
+			return fmt.Errorf("unexpected %s signature for %s key", format, keyType)
+		}
+		pub, err := ecdsa.ParseUncompressedPublicKey(vc.curve, q)
+		if err != nil {
+			return fmt.Errorf("bad %s key: %w", keyType, err)
+		}
+		br := &Reader{buf: blob}
+		r, s := new(big.Int).SetBytes(br.Bytes()), new(big.Int).SetBytes(br.Bytes())
+		if err := br.Done(); err != nil {
+			return fmt.Errorf("bad %s signature: %w", format, err)
+		}
+		if !ecdsa.Verify(pub, Digest(vc.hash, data), r, s) {
+			return ErrBadSignature
+		}
+		return nil
+	case "ssh-rsa":
+		e, n := new(big.Int).SetBytes(kr.Bytes()), new(big.Int).SetBytes(kr.Bytes())
+		if err := kr.Done(); err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return errors.New("bad ssh-rsa key")
+		}
+		var h crypto.Hash
+		switch format {
+		case "ssh-rsa":
+			h = crypto.SHA1
+		case "rsa-sha2-256":
+			h = crypto.SHA256
+		case "rsa-sha2-512":
+			h = crypto.SHA512
+		default:
+			return fmt.Errorf("unexpected %s signature for %s key", format, keyType)
+		}
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if err := rsa.VerifyPKCS1v15(pub, h, Digest(h, data), blob); err != nil {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("unable to verify signatures of %s keys", keyType)
+}
+
+// Digest hashes data with one of SHA-1 and SHA-2 functions ssh signatures use, anything else is SHA-512.
+func Digest(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA1:
+		d := sha1.Sum(data)
+		return d[:]
+	case crypto.SHA256:
+		d := sha256.Sum256(data)
+		return d[:]
+	case crypto.SHA384:
+		d := sha512.Sum384(data)
+		return d[:]
+	default:
+		d := sha512.Sum512(data)
+		return d[:]
+	}
+}
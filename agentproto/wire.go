@@ -0,0 +1,157 @@
+package agentproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Parsing errors.
+var (
+	ErrShortMessage = errors.New("message is too short")
+	ErrTrailingData = errors.New("unexpected data at the end of message")
+	ErrEmptyMessage = errors.New("empty message")
+)
+
+// Reader consumes wire encoded primitives from buffer. First error sticks and all subsequent reads return zero values.
+type Reader struct {
+	buf []byte
+	err error
+}
+
+// NewReader returns Reader for buf.
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+// Err returns first error encountered.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Len returns number of unread bytes.
+func (r *Reader) Len() int {
+	return len(r.buf)
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Byte reads single byte.
+func (r *Reader) Byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 1 {
+		r.fail(ErrShortMessage)
+		return 0
+	}
+	v := r.buf[0]
+	r.buf = r.buf[1:]
+	return v
+}
+
+// Bool reads boolean encoded as single byte.
+func (r *Reader) Bool() bool {
+	v := r.Byte()
+	if v > 1 {
+		r.fail(fmt.Errorf("bad boolean value %d", v))
+	}
+	return v == 1
+}
+
+// Uint32 reads big endian uint32.
+func (r *Reader) Uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.fail(ErrShortMessage)
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+// Uint64 reads big endian uint64.
+func (r *Reader) Uint64() uint64 {
+	return uint64(r.Uint32())<<32 | uint64(r.Uint32())
+}
+
+// Bytes returns content of next wire string (or mpint) without copying.
+func (r *Reader) Bytes() []byte {
+	l := r.Uint32()
+	if r.err != nil {
+		return nil
+	}
+	if uint32(len(r.buf)) < l {
+		r.fail(ErrShortMessage)
+		return nil
+	}
+	v := r.buf[:l:l]
+	r.buf = r.buf[l:]
+	return v
+}
+
+// Text returns content of next wire string as Go string.
+func (r *Reader) Text() string {
+	return string(r.Bytes())
+}
+
+// Rest consumes whatever is left in buffer.
+func (r *Reader) Rest() []byte {
+	if r.err != nil {
+		return nil
+	}
+	v := r.buf
+	r.buf = nil
+	return v
+}
+
+// Done reports sticky error or complains about unconsumed data.
+func (r *Reader) Done() error {
+	if r.err == nil && len(r.buf) != 0 {
+		r.err = ErrTrailingData
+	}
+	return r.err
+}
+
+// AppendUint32 appends big endian uint32.
+func AppendUint32(b []byte, v uint32) []byte {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+// AppendBytes appends v as wire string.
+func AppendBytes(b, v []byte) []byte {
+	b = AppendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends v as wire string.
+func AppendString(b []byte, v string) []byte {
+	b = AppendUint32(b, uint32(len(v)))
+	return append(b, v...)
+}
+
+// AppendBool appends boolean as single byte.
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// AppendMpint appends v as wire mpint: two's complement big endian number without unnecessary leading bytes. Only
+// non-negative numbers are supported.
+func AppendMpint(b []byte, v *big.Int) []byte {
+	buf := v.Bytes()
+	if len(buf) > 0 && buf[0]&0x80 != 0 {
+		buf = append([]byte{0}, buf...)
+	}
+	return AppendBytes(b, buf)
+}
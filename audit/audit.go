@@ -0,0 +1,72 @@
+// Package audit records agent operations as JSON lines.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/rotate"
+)
+
+// Record describes single agent request and its outcome.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Listener string    `json:"listener"`
+	Type     string    `json:"type"`
+	Key      string    `json:"key,omitempty"`     // fingerprint of the key request refers to
+	Comment  string    `json:"comment,omitempty"` // key comment when known
+	Data     *Signed   `json:"data,omitempty"`    // what data to be signed is
+	Result   string    `json:"result"`            // reply type or "refused" when proxy did not let request through
+	Reason   string    `json:"reason,omitempty"`  // why request was refused or failed
+	Latency  float64   `json:"latency_ms"`
+}
+
+// Signed summarizes data of sign request.
+type Signed struct {
+	Kind      string `json:"kind"` // "userauth", "sshsig" or "unknown"
+	Size      int    `json:"size"`
+	User      string `json:"user,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Session   string `json:"session,omitempty"`   // hex encoded session identifier
+	Host      string `json:"host,omitempty"`      // fingerprint of server host key
+	HostName  string `json:"host_name,omitempty"` // names of the server from known_hosts
+	Namespace string `json:"namespace,omitempty"`
+	Hash      string `json:"hash,omitempty"` // hash algorithm of SSHSIG
+}
+
+// Sink receives audit records. Implementations must be safe for concurrent use.
+type Sink interface {
+	Audit(r *Record)
+}
+
+// File is Sink writing records into file which is rotated when it grows over size limit.
+type File struct {
+	*rotate.File
+}
+
+// Open opens (or creates) audit file. When file grows over maxSize bytes it is renamed to path.1, previous path.1
+// becomes path.2 and so on, only keep old files are retained.
+func Open(path string, maxSize int64, keep int) (*File, error) {
+	rf, err := rotate.Open("audit", path, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: rf}, nil
+}
+
+// Audit writes record as single line. Errors are logged, they never affect agent operations.
+func (a *File) Audit(r *Record) {
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		logging.Errorf("Unable to encode audit record: %s", err)
+		return
+	}
+	// records sent after Close are dropped
+	if _, err := a.Write(append(line, '\n')); err != nil && !errors.Is(err, os.ErrClosed) {
+		logging.Errorf("%s", err)
+	}
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordFormat(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		record Record
+		want   string
+	}{
+		{
+			name:   "refused add",
+			record: Record{Time: at, Listener: "wsl", Type: "SSH_AGENTC_ADD_IDENTITY", Key: "SHA256:x", Comment: "me@work", Result: "refused", Reason: "listener is read-only", Latency: 0.5},
+			want:   `{"time":"2024-05-01T12:00:00Z","listener":"wsl","type":"SSH_AGENTC_ADD_IDENTITY","key":"SHA256:x","comment":"me@work","result":"refused","reason":"listener is read-only","latency_ms":0.5}`,
+		},
+		{
+			name:   "list without optional fields",
+			record: Record{Time: at, Listener: "wsl", Type: "SSH_AGENTC_REQUEST_IDENTITIES", Result: "SSH_AGENT_IDENTITIES_ANSWER"},
+			want:   `{"time":"2024-05-01T12:00:00Z","listener":"wsl","type":"SSH_AGENTC_REQUEST_IDENTITIES","result":"SSH_AGENT_IDENTITIES_ANSWER","latency_ms":0}`,
+		},
+		{
+			name: "sign",
+			record: Record{Time: at, Listener: "wsl", Type: "SSH_AGENTC_SIGN_REQUEST", Key: "SHA256:x", Result: "SSH_AGENT_SIGN_RESPONSE", Latency: 1,
+				Data: &Signed{Kind: "userauth", Size: 100, User: "git", Service: "ssh-connection", Session: "00ff", Host: "SHA256:h", HostName: "github.com"}},
+			want: `{"time":"2024-05-01T12:00:00Z","listener":"wsl","type":"SSH_AGENTC_SIGN_REQUEST","key":"SHA256:x",` +
+				`"data":{"kind":"userauth","size":100,"user":"git","service":"ssh-connection","session":"00ff","host":"SHA256:h","host_name":"github.com"},` +
+				`"result":"SSH_AGENT_SIGN_RESPONSE","latency_ms":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			f, err := Open(path, 1<<20, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Audit(&tt.record)
+			f.Close()
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want+"\n" {
+				t.Errorf("record\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := Open(path, 300, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		f.Audit(&Record{Listener: "wsl", Type: "SSH_AGENTC_REQUEST_IDENTITIES", Result: "SSH_AGENT_IDENTITIES_ANSWER"})
+	}
+	f.Close()
+	f.Audit(&Record{Listener: "late"}) // dropped
+
+	total := 0
+	for _, name := range []string{path, path + ".1"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 300 {
+			t.Errorf("%s has %d bytes, limit is 300", name, len(data))
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			var r Record
+			if err := json.Unmarshal([]byte(line), &r); err != nil || r.Listener != "wsl" {
+				t.Errorf("%s line %q: %+v, %v", name, line, r, err)
+			}
+			total++
+		}
+	}
+	if _, err := os.Stat(path + ".2"); err == nil {
+		t.Error("more old files kept than asked")
+	}
+	// two records fit in a file, older ones are gone
+	if total != 4 {
+		t.Errorf("%d records kept", total)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wsl-ssh-agent/config"
+	"wsl-ssh-agent/events"
+	"wsl-ssh-agent/knownhosts"
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/systray"
+)
+
+const configCheck = 2 * time.Second // how often configuration file is checked for changes
+
+var (
+	configPath string
+	cmdline    = make(map[string]bool) // flags given explicitly, they override configuration file
+	loaded     atomic.Pointer[config.Config]
+	reloadMu   sync.Mutex
+	active     atomic.Pointer[policyArgs] // replaced as a whole, never modified
+)
+
+// policyArgs are program arguments which could be changed while program runs. On reload complete new set is built and
+// validated before it replaces one in effect, so failed reload changes nothing.
+type policyArgs struct {
+	ignorelock  bool
+	logLevel    logging.Level
+	knownHosts  urlList
+	confirm     string
+	confirmTime time.Duration
+	askpass     string
+	signHosts   urlList
+	noForward   string
+	onSessions  urlList
+	hosts       *knownhosts.DB // opened by policy
+}
+
+// current returns policy arguments in effect.
+func current() *policyArgs {
+	return active.Load()
+}
+
+// defaultConfigPath returns %APPDATA%\wsl-ssh-agent\config.toml.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wsl-ssh-agent", "config.toml")
+}
+
+// loadConfig reads configuration file, missing file gives defaults unless it was given explicitly.
+func loadConfig() (*config.Config, error) {
+	if len(configPath) == 0 {
+		return config.Default(), nil
+	}
+	cfg, err := config.Load(configPath)
+	if errors.Is(err, fs.ErrNotExist) && !cmdline["config"] {
+		return config.Default(), nil
+	}
+	return cfg, err
+}
+
+// pick sets program argument from configuration unless flag was given on command line.
+func pick[T any](name string, dst *T, v T) {
+	if !cmdline[name] {
+		*dst = v
+	}
+}
+
+// applyConfig sets program arguments from configuration.
+func applyConfig(cfg *config.Config) {
+	pick("socket", &socketName, cfg.Socket)
+	pick("pipe", &pipeName, cfg.Pipe)
+	pick("backend", &backendURLs, urlList(cfg.Backends))
+	pick("fallback", &fallback, cfg.Fallback)
+	pick("compat", &compat, cfg.Compat)
+	pick("envname", &envName, cfg.EnvName)
+	pick("setenv", &setenv, cfg.SetEnv)
+	pick("readonly", &readOnly, cfg.ReadOnly)
+	pick("debug", &debug, cfg.Debug)
+	pick("metrics", &metricsAddr, cfg.Metrics)
+	pick("log-file", &logFile, cfg.Log.File)
+	pick("log-size", &logSize, cfg.Log.Size)
+	var urls urlList
+	for _, l := range cfg.Listeners {
+		urls = append(urls, l.String())
+	}
+	pick("listen", &listenURLs, urls)
+	pick("audit", &auditOn, cfg.Audit.Enabled)
+	pick("audit-size", &auditSize, cfg.Audit.Size)
+	pick("port", &clipPort, cfg.Clipboard.Port)
+	pick("line-endings", &clipLE, cfg.Clipboard.LineEndings)
+	active.Store(applyPolicy(cfg))
+	loaded.Store(cfg)
+}
+
+// applyPolicy returns policy arguments from configuration and command line.
+func applyPolicy(cfg *config.Config) *policyArgs {
+	p := &policyArgs{ignorelock: ignorelock, logLevel: logLevel, knownHosts: knownHosts, confirm: confirm,
+		confirmTime: confirmTime, askpass: askpass, signHosts: signHosts, noForward: noForward, onSessions: onSessions}
+	pick("nolock", &p.ignorelock, cfg.NoLock)
+	pick("log-level", &p.logLevel, cfg.Log.Level)
+	pick("known-hosts", &p.knownHosts, urlList(cfg.KnownHosts))
+	pick("confirm", &p.confirm, string(cfg.Confirm.Keys))
+	pick("confirm-timeout", &p.confirmTime, time.Duration(cfg.Confirm.Timeout))
+	pick("askpass", &p.askpass, cfg.Confirm.Askpass)
+	var rules urlList
+	for _, h := range cfg.SignHosts {
+		rules = append(rules, h.String())
+	}
+	pick("sign-hosts", &p.signHosts, rules)
+	pick("no-forwarding", &p.noForward, string(cfg.NoForwarding))
+	var events urlList
+	for name, acts := range cfg.Session {
+		list := make([]string, 0, len(acts))
+		for _, a := range acts {
+			list = append(list, string(a))
+		}
+		events = append(events, name+"="+strings.Join(list, ","))
+	}
+	sort.Strings(events)
+	pick("on-session", &p.onSessions, events)
+	return p
+}
+
+// restartOnly returns settings which could not be changed without restart.
+func restartOnly(cfg *config.Config) string {
+	return fmt.Sprintf("%v %v %v %v %v %v %v %v %v %v %v %v %+v %+v %+v", cfg.Socket, cfg.Pipe, cfg.Backends, cfg.Fallback,
+		cfg.Compat, cfg.EnvName, cfg.SetEnv, cfg.ReadOnly, cfg.Debug, cfg.Metrics, cfg.Log.File, cfg.Log.Size, cfg.Listeners,
+		cfg.Audit, cfg.Clipboard)
+}
+
+// reload re-reads configuration file on change or on user request, problems are shown as notifications.
+func reload() {
+	if err := reloadConfig(); err != nil {
+		log.Printf("Unable to reload configuration: %s", err)
+		systray.ShowNotification(title, err.Error())
+	}
+}
+
+// reloadConfig re-reads configuration file and applies policies to running server without dropping connections.
+func reloadConfig() error {
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	p := applyPolicy(cfg)
+	opts, rules, err := policy(p)
+	if err != nil {
+		return err
+	}
+	active.Store(p)
+	logging.SetLevel(effectiveLevel())
+	server.Reconfigure(opts...)
+	sessions.SetRules(rules)
+	syncClipLock()
+	if restartOnly(loaded.Load()) != restartOnly(cfg) {
+		log.Print("Some configuration changes take effect after restart")
+		systray.ShowNotification(title, "Configuration reloaded, some changes take effect after restart")
+	}
+	loaded.Store(cfg)
+	log.Printf("Configuration reloaded from %s", configPath)
+	bus.Publish(&events.Event{Type: events.ConfigReloaded, Config: configPath})
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"wsl-ssh-agent/control"
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/proxy"
+	"wsl-ssh-agent/systray"
+)
+
+const ctlUsage = `Usage:
+	%s ctl [options] command [argument]
+
+Commands:
+	status                Show state of the running instance
+	keys                  List keys of backend agent
+	lock                  Refuse all agent requests
+	unlock                Lift lock set by "lock" (session lock stays)
+	reload                Re-read configuration file
+	connections           List open client connections
+	kill-connection ID    Close client connection
+	events                Print live events as JSON lines until interrupted
+	metrics               Print metrics in Prometheus text format
+	logs [-f]             Print recent log messages, with -f keep printing new ones
+	log-level [LEVEL]     Show or change log level: debug, info, warn or error
+	quit                  Stop running instance
+
+Options:
+
+`
+
+// controlPath returns name of control socket which is created next to the agent socket.
+func controlPath(socket string) string {
+	dir := os.TempDir()
+	if len(socket) > 0 {
+		dir = filepath.Dir(socket)
+	}
+	return filepath.Join(dir, title+".ctl")
+}
+
+// ctlStatus is reply to status command.
+type ctlStatus struct {
+	*proxy.Status
+	Socket      string `json:"socket"`
+	Config      string `json:"config,omitempty"`
+	Session     string `json:"session_rules"`
+	Connections int    `json:"connections"`
+}
+
+// serveControl starts control endpoint of running instance.
+func serveControl() (net.Listener, error) {
+
+	ctl := control.NewServer()
+	ctl.Handle("status", func(json.RawMessage) (any, error) {
+		st := &ctlStatus{Status: server.Status(), Socket: socketName, Session: sessions.Rules().String(), Connections: len(server.Connections())}
+		if loaded.Load() != nil {
+			st.Config = configPath
+		}
+		return st, nil
+	})
+	ctl.Handle("keys", func(json.RawMessage) (any, error) {
+		return server.Keys()
+	})
+	ctl.Handle("lock", func(json.RawMessage) (any, error) {
+		server.SetLocked(true)
+		return nil, nil
+	})
+	ctl.Handle("unlock", func(json.RawMessage) (any, error) {
+		server.SetLocked(false)
+		return nil, nil
+	})
+	ctl.Handle("reload", func(json.RawMessage) (any, error) {
+		return nil, reloadConfig()
+	})
+	ctl.Handle("connections", func(json.RawMessage) (any, error) {
+		return server.Connections(), nil
+	})
+	ctl.Handle("kill-connection", func(args json.RawMessage) (any, error) {
+		var arg struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.Unmarshal(args, &arg); err != nil {
+			return nil, fmt.Errorf("bad arguments: %w", err)
+		}
+		if !server.DropConnection(arg.ID) {
+			return nil, fmt.Errorf("no connection %d", arg.ID)
+		}
+		return nil, nil
+	})
+	ctl.HandleStream("events", func(_ json.RawMessage, send func(any) error, done <-chan struct{}) error {
+		sub := bus.Subscribe()
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					return errors.New("events were lost, subscriber did not keep up")
+				}
+				if err := send(e); err != nil {
+					return err
+				}
+			case <-done:
+				return nil
+			}
+		}
+	})
+	ctl.Handle("metrics", func(json.RawMessage) (any, error) {
+		return registry.Text(), nil
+	})
+	ctl.Handle("logs", func(json.RawMessage) (any, error) {
+		return logRing.Entries(), nil
+	})
+	ctl.HandleStream("logs-follow", func(_ json.RawMessage, send func(any) error, done <-chan struct{}) error {
+		entries, ch := logRing.Follow()
+		defer logRing.Unfollow(ch)
+		for _, e := range entries {
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return errors.New("log messages were lost, follower did not keep up")
+				}
+				if err := send(e); err != nil {
+					return err
+				}
+			case <-done:
+				return nil
+			}
+		}
+	})
+	ctl.Handle("log-level", func(args json.RawMessage) (any, error) {
+		var arg struct {
+			Level *logging.Level `json:"level"`
+		}
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &arg); err != nil {
+				return nil, fmt.Errorf("bad arguments: %w", err)
+			}
+		}
+		if arg.Level != nil {
+			logging.SetLevel(*arg.Level)
+			log.Printf("Log level set to %s", *arg.Level)
+		}
+		return logging.GetLevel(), nil
+	})
+	ctl.Handle("quit", func(json.RawMessage) (any, error) {
+		go systray.Quit()
+		return nil, nil
+	})
+
+	ln, err := control.Listen(controlPath(socketName))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Control socket %s", ln.Addr())
+	go func() {
+		if err := ctl.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Control serve ended: %s", err)
+		}
+	}()
+	return ln, nil
+}
+
+// ctlMain is client of control endpoint, it runs single command and prints its result as JSON.
+func ctlMain(args []string, stdout, stderr io.Writer) int {
+
+	var ctlPath string
+
+	flags := flag.NewFlagSet(title+" ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&configPath, "config", defaultConfigPath(), "Configuration `file` of running instance to find agent socket")
+	flags.StringVar(&socketName, "socket", "", "Agent socket `path` of running instance")
+	flags.StringVar(&ctlPath, "control", "", "Control socket `path`, overrides location derived from agent socket")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, ctlUsage, title)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if len(ctlPath) == 0 {
+		flags.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		pick("socket", &socketName, cfg.Socket)
+		ctlPath = controlPath(socketName)
+	}
+
+	command := flags.Arg(0)
+	var cmdArgs any
+	switch command {
+	case "logs":
+		if flags.NArg() > 2 || (flags.NArg() == 2 && flags.Arg(1) != "-f") {
+			fmt.Fprintln(stderr, "logs takes only -f")
+			return 2
+		}
+		if flags.NArg() == 2 {
+			command = "logs-follow"
+		}
+	case "log-level":
+		if flags.NArg() > 2 {
+			fmt.Fprintln(stderr, "log-level takes single level")
+			return 2
+		}
+		if flags.NArg() == 2 {
+			level, err := logging.ParseLevel(flags.Arg(1))
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 2
+			}
+			cmdArgs = map[string]logging.Level{"level": level}
+		}
+	case "kill-connection":
+		if flags.NArg() != 2 {
+			fmt.Fprintln(stderr, "kill-connection needs connection ID")
+			return 2
+		}
+		id, err := strconv.ParseUint(flags.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Fprintf(stderr, "bad connection ID: %s\n", err)
+			return 2
+		}
+		cmdArgs = map[string]uint64{"id": id}
+	default:
+		if flags.NArg() != 1 {
+			fmt.Fprintf(stderr, "%s takes no arguments\n", command)
+			return 2
+		}
+	}
+
+	c, err := control.Dial(ctlPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: is %s running?\n", err, title)
+		return 1
+	}
+	defer c.Close()
+
+	switch command {
+	case "logs-follow":
+		err := c.Stream(command, cmdArgs, func(res json.RawMessage) error {
+			return printEntries(stdout, res, false)
+		})
+		fmt.Fprintln(stderr, err)
+		return 1
+	case "logs":
+		res, err := c.Call(command, cmdArgs)
+		if err == nil {
+			err = printEntries(stdout, res, true)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if command == "events" {
+		err := c.Stream(command, cmdArgs, func(res json.RawMessage) error {
+			_, err := fmt.Fprintln(stdout, string(res))
+			return err
+		})
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	res, err := c.Call(command, cmdArgs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	var text string
+	if err := json.Unmarshal(res, &text); err == nil {
+		fmt.Fprint(stdout, text)
+		return 0
+	}
+	if len(res) > 0 {
+		var out bytes.Buffer
+		if err := json.Indent(&out, res, "", "  "); err != nil {
+			out.Write(res)
+		}
+		fmt.Fprintln(stdout, out.String())
+	}
+	return 0
+}
+
+// printEntries prints log entries (or single entry) as text lines.
+func printEntries(w io.Writer, res json.RawMessage, list bool) error {
+	var entries []*logging.Entry
+	if list {
+		if err := json.Unmarshal(res, &entries); err != nil {
+			return fmt.Errorf("bad log entries: %w", err)
+		}
+	} else {
+		e := &logging.Entry{}
+		if err := json.Unmarshal(res, e); err != nil {
+			return fmt.Errorf("bad log entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	for _, e := range entries {
+		if _, err := fmt.Fprintln(w, e.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/util"
+)
+
+// setupLogging keeps log in memory for "ctl logs", sends it to debugger with -debug and to log file when requested.
+func setupLogging() error {
+
+	sinks := []logging.Sink{logRing}
+	if debug {
+		sinks = append(sinks, logging.NewTextSink("["+title+"] ", util.NewDebugWriter()))
+	}
+	if len(logFile) > 0 {
+		f, err := logging.OpenFile(logFile, int64(logSize)<<20, logKeep)
+		if err != nil {
+			return err
+		}
+		logOut = f
+		sinks = append(sinks, f)
+	}
+	logging.Install(sinks...)
+	logging.SetLevel(effectiveLevel())
+	return nil
+}
+
+// effectiveLevel returns log level to use, -debug implies debug level unless level is given explicitly.
+func effectiveLevel() logging.Level {
+	if debug && !cmdline["log-level"] {
+		return logging.LevelDebug
+	}
+	return current().logLevel
+}
@@ -1,56 +1,104 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/Microsoft/go-winio"
 	si "github.com/allan-simon/go-singleinstance"
 	clip "github.com/rupor-github/gclpr/server"
 	cliputil "github.com/rupor-github/gclpr/util"
-	"golang.org/x/sys/windows"
 
+	"wsl-ssh-agent/audit"
+	"wsl-ssh-agent/config"
+	"wsl-ssh-agent/events"
+	"wsl-ssh-agent/keyring"
+	"wsl-ssh-agent/knownhosts"
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/metrics"
 	"wsl-ssh-agent/misc"
+	"wsl-ssh-agent/proxy"
+	"wsl-ssh-agent/session"
 	"wsl-ssh-agent/systray"
 	"wsl-ssh-agent/util"
 )
 
 const (
-	title   = "wsl-ssh-agent-gui"
-	tooltip = "Helper to interface with Windows ssh-agent.exe service from WSL"
+	auditKeep = 5 // number of rotated audit files to keep
+	logKeep   = 5 // number of rotated log files to keep
+	logLines  = 1000
+	title     = "wsl-ssh-agent-gui"
+	tooltip   = "Helper to interface with Windows ssh-agent.exe service from WSL"
 )
 
 var (
 	// Program arguments.
-	debug      bool
-	help       bool
-	ignorelock bool
-	socketName string
-	pipeName   string
-	setenv     bool
-	clipPort   int
-	clipLE     string
-	clipCancel context.CancelFunc
-	clipCtx    context.Context
-	clipHelp   string
-	envName    = "SSH_AUTH_SOCK"
-	usage      string
-	locked     int32
-	cli        = flag.NewFlagSet(title, flag.ContinueOnError)
+	debug       bool
+	help        bool
+	ignorelock  bool
+	socketName  string
+	pipeName    string
+	backendURLs urlList
+	fallback    bool
+	listenURLs  urlList
+	listeners   []proxy.Listener
+	confirm     string
+	confirmTime time.Duration
+	askpass     string
+	auditOn     bool
+	auditSize   int
+	auditFile   *audit.File
+	knownHosts  urlList
+	signHosts   urlList
+	noForward   string
+	compat      bool
+	readOnly    bool
+	onSessions  urlList
+	sessions    *session.Engine
+	bus         = events.NewBus()
+	registry    = metrics.NewRegistry()
+	metricsAddr string
+	logLevel    logging.Level
+	logFile     string
+	logSize     int
+	logOut      *logging.File
+	logRing     = logging.NewRing(logLines)
+	setenv      bool
+	clipPort    int
+	clipLE      string
+	clipCancel  context.CancelFunc
+	clipCtx     context.Context
+	clipHelp    string
+	envName     string
+	usage       string
+	locked      int32 // remote clipboard refuses requests while it is set, see syncClipLock
+	clipMu      sync.Mutex
+	sessionLock bool // guarded by clipMu
+	server      *proxy.Server
+	cli         = flag.NewFlagSet(title, flag.ContinueOnError)
 )
 
+// urlList collects values of repeatable flag.
+type urlList []string
+
+func (l *urlList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *urlList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func onReady() {
 
 	systray.SetIcon(systray.MakeIntResource(1000))
@@ -58,6 +106,7 @@ func onReady() {
 	systray.SetTooltip(tooltip)
 
 	help := systray.AddMenuItem("About", "Shows application help")
+	reloadItem := systray.AddMenuItem("Reload", "Reloads configuration file")
 	systray.AddSeparator()
 	quit := systray.AddMenuItem("Exit", "Exits application")
 
@@ -66,6 +115,8 @@ func onReady() {
 			select {
 			case <-help.ClickedCh:
 				cli.Usage()
+			case <-reloadItem.ClickedCh:
+				reload()
 			case <-quit.ClickedCh:
 				systray.Quit()
 				return
@@ -74,15 +125,51 @@ func onReady() {
 	}()
 }
 
+// sessionEvents maps Windows session notifications to policy events.
+var sessionEvents = map[systray.SessionEvent]session.Event{
+	systray.SesConsoleConnect:    session.ConsoleConnect,
+	systray.SesConsoleDisconnect: session.ConsoleDisconnect,
+	systray.SesRemoteConnect:     session.RemoteConnect,
+	systray.SesRemoteDisconnect:  session.RemoteDisconnect,
+	systray.SesLogon:             session.Logon,
+	systray.SesLogoff:            session.Logoff,
+	systray.SesLock:              session.Lock,
+	systray.SesUnlock:            session.Unlock,
+	systray.SesRemoteControl:     session.RemoteControl,
+}
+
 func onSession(e systray.SessionEvent) {
 	log.Printf("Session event %s", e)
+	// remote clipboard follows session lock state
 	switch e {
 	case systray.SesLock:
-		atomic.StoreInt32(&locked, 1)
+		setSessionLock(true)
+		bus.Publish(&events.Event{Type: events.SessionLock})
 	case systray.SesUnlock:
-		atomic.StoreInt32(&locked, 0)
-	default:
+		setSessionLock(false)
+		bus.Publish(&events.Event{Type: events.SessionUnlock})
+	}
+	if ev, ok := sessionEvents[e]; ok && sessions != nil {
+		sessions.Handle(ev)
+	}
+}
+
+func setSessionLock(on bool) {
+	clipMu.Lock()
+	sessionLock = on
+	clipMu.Unlock()
+	syncClipLock()
+}
+
+// syncClipLock makes remote clipboard refuse requests while session is locked unless -nolock is in effect.
+func syncClipLock() {
+	clipMu.Lock()
+	defer clipMu.Unlock()
+	var v int32
+	if sessionLock && !current().ignorelock {
+		v = 1
 	}
+	atomic.StoreInt32(&locked, v)
 }
 
 func onExit() {
@@ -100,94 +187,37 @@ func makeSocketName() (string, error) {
 	return f.Name(), nil
 }
 
-func serve(ln net.Listener, pipeName string, query func(name string, req []byte) (resp []byte, err error)) {
+// makeBackend creates agent backend from program arguments. Several backends are aggregated, in-memory fallback is
+// used when single backend is unreachable or as a last resort among several.
+func makeBackend() (proxy.Backend, error) {
 
-	var badResponse = [...]byte{0, 0, 0, 1, 5}
-
-	defer ln.Close()
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Printf("Listener Accept error on %s - '%s'", pipeName, err)
-			return
+	if len(backendURLs) == 0 {
+		if len(pipeName) == 0 {
+			pipeName = util.AgentPipeName
 		}
-		go func(conn net.Conn) {
-			defer conn.Close()
-
-			handle := fmt.Sprintf("%v", conn)
-
-			log.Printf("[%s] Incoming: %s", handle, conn.LocalAddr())
-
-			reader := bufio.NewReader(conn)
-			for {
-				log.Printf("[%s] Reading loop", handle)
-
-				lenBuf := make([]byte, 4)
-				_, err := io.ReadFull(reader, lenBuf)
-				if err != nil {
-					log.Printf("[%s] ReadFull error '%s'", handle, err)
-					return
-				}
-				log.Printf("[%s] Got msg length for request to ssh-agent.exe: %s)", handle, fmt.Sprintf("%+v", lenBuf))
-
-				l := binary.BigEndian.Uint32(lenBuf)
-				buf := make([]byte, l)
-				_, err = io.ReadFull(reader, buf)
-				if err != nil {
-					log.Printf("[%s] ReadFull error '%s'", handle, err)
-					return
-				}
-				log.Printf("[%s] Got request for query: %d)", handle, len(buf))
-
-				var res []byte
-				if !ignorelock && atomic.LoadInt32(&locked) == 1 {
-					log.Print("Session is locked")
-					res = badResponse[:]
-				} else {
-					res, err = query(pipeName, append(lenBuf, buf...))
-					if err != nil {
-						// If for some reason talking to ssh-agent.exe failed send back error
-						log.Printf("[%s] query error '%s'", handle, err)
-						res = badResponse[:]
-					}
-					log.Printf("[%s] Got query response: %d bytes", handle, len(res))
-				}
-
-				_, err = conn.Write(res)
-				if err != nil {
-					log.Printf("[%s] Conn.Write error '%s'", handle, err)
-					return
-				}
-				log.Printf("[%s] Sent query response back", handle)
-			}
-		}(conn)
+		backendURLs = urlList{pipeName}
 	}
-}
 
-func queryAgent(pipeName string, buf []byte) (result []byte, err error) {
-
-	conn, err := winio.DialPipe(pipeName, nil)
-	if err != nil {
-		return nil, fmt.Errorf("cannot connect to pipe %s: %w", pipeName, err)
+	backends := make([]proxy.Backend, 0, len(backendURLs)+1)
+	for _, uri := range backendURLs {
+		be, err := proxy.ParseBackend(uri)
+		if err != nil {
+			return nil, fmt.Errorf("bad backend: %w", err)
+		}
+		backends = append(backends, be)
 	}
-	defer conn.Close()
-	log.Printf("Connected to %s: %d", pipeName, len(buf))
 
-	l, err := conn.Write(buf)
-	if err != nil {
-		return nil, fmt.Errorf("cannot write to pipe %s: %w", pipeName, err)
+	if fallback {
+		mem := proxy.NewLocalBackend(proxy.SchemeMemory+":", keyring.New())
+		if len(backends) == 1 {
+			return proxy.NewFallbackBackend(backends[0], mem), nil
+		}
+		backends = append(backends, mem)
 	}
-	log.Printf("Sent to %s: %d", pipeName, l)
-
-	reader := bufio.NewReader(conn)
-	res := make([]byte, util.MaxAgentMsgLen)
-
-	l, err = reader.Read(res)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read from pipe %s: %w", pipeName, err)
+	if len(backends) == 1 {
+		return backends[0], nil
 	}
-	log.Printf("Received from %s: %d", pipeName, l)
-	return res[0:l], nil
+	return proxy.NewMultiBackend(backends...), nil
 }
 
 func run() (err error) {
@@ -216,40 +246,135 @@ func run() (err error) {
 		}()
 	}
 
-	if len(pipeName) == 0 {
-		pipeName = util.AgentPipeName
+	backend, err := makeBackend()
+	if err != nil {
+		return err
 	}
 
-	_, err = os.Stat(socketName)
-	if err == nil || !os.IsNotExist(err) {
-		err = windows.Unlink(socketName)
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+	primary := proxy.SchemeUnix + ":" + socketName
+	if readOnly {
+		primary += "?readonly"
+	}
+	for _, uri := range append([]string{primary}, listenURLs...) {
+		ln, err := proxy.Listen(uri)
 		if err != nil {
-			return fmt.Errorf("failed to unlink socket %s: %w", socketName, err)
+			return err
 		}
+		listeners = append(listeners, ln)
+		log.Printf("Listening on %s", ln.Name())
 	}
 
-	sock, err := net.Listen("unix", socketName)
+	opts, rules, err := policy(current())
 	if err != nil {
-		return fmt.Errorf("could not open socket %s: %w", socketName, err)
+		return err
+	}
+	opts = append(opts, proxy.WithVersion(misc.GetVersion()), proxy.WithConstraints(), proxy.WithEvents(bus),
+		proxy.WithMetrics(proxy.NewMetrics(registry)))
+	if compat {
+		opts = append(opts, proxy.WithCompat())
+	}
+	if auditOn {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("unable to find audit directory: %w", err)
+		}
+		if auditFile, err = audit.Open(filepath.Join(dir, "wsl-ssh-agent", "audit.jsonl"), int64(auditSize)<<20, auditKeep); err != nil {
+			return err
+		}
+		defer auditFile.Close()
+		opts = append(opts, proxy.WithAudit(auditFile))
+	}
+	server = proxy.NewServer(backend, opts...)
+	sessions = session.New(server, rules, func(text string) {
+		systray.ShowNotification(title, text)
+	})
+	ctl, err := serveControl()
+	if err != nil {
+		return err
+	}
+	defer ctl.Close()
+	if len(metricsAddr) > 0 {
+		srv, err := serveMetrics(metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer srv.Close()
 	}
-	defer func() {
-		sock.Close()
-		// Just in case - should not be needed
-		_ = os.Remove(socketName)
-	}()
-	log.Printf("Listening on Unix socket: %s", socketName)
 
-	go func() {
-		serve(sock, pipeName, queryAgent)
-		// If for some reason process breaks - exit
-		log.Printf("Quiting - serve on %s ended", socketName)
-		systray.Quit()
-	}()
+	if len(configPath) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go config.Watch(ctx, configPath, configCheck, reload)
+	}
+
+	for _, ln := range listeners {
+		go func() {
+			err := server.Serve(ln)
+			// If for some reason process breaks - exit
+			log.Printf("Quiting - serve on %s ended: %s", ln.Name(), err)
+			systray.Quit()
+		}()
+	}
 
 	systray.Run(onReady, onExit, onSession)
 	return nil
 }
 
+// policy builds server options and session rules which could be changed while program runs and opens known hosts.
+func policy(p *policyArgs) ([]proxy.Option, session.Rules, error) {
+
+	rules := session.DefaultRules()
+	if p.ignorelock {
+		rules = session.Rules{}
+	}
+	for _, rule := range p.onSessions {
+		if err := rules.Add(rule); err != nil {
+			return nil, nil, fmt.Errorf("bad session rule: %w", err)
+		}
+	}
+
+	approver := proxy.NewDialogApprover(title)
+	if len(p.askpass) > 0 {
+		approver = proxy.NewCommandApprover(p.askpass)
+	}
+	opts := []proxy.Option{proxy.WithApprover(approver, p.confirmTime)}
+	if len(p.confirm) > 0 {
+		keys, err := proxy.NewKeyFilter(p.confirm)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad confirm key patterns: %w", err)
+		}
+		opts = append(opts, proxy.WithConfirm(keys))
+	}
+	var policies []*proxy.HostPolicy
+	for _, rule := range p.signHosts {
+		hp, err := proxy.NewHostPolicy(rule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad sign-hosts rule: %w", err)
+		}
+		policies = append(policies, hp)
+	}
+	opts = append(opts, proxy.WithHostPolicy(policies...))
+	if len(p.noForward) > 0 {
+		keys, err := proxy.NewKeyFilter(p.noForward)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad no-forwarding key patterns: %w", err)
+		}
+		opts = append(opts, proxy.WithNoForwarding(keys))
+	}
+	var hostFiles []string
+	if home, err := os.UserHomeDir(); err == nil {
+		hostFiles = append(hostFiles, filepath.Join(home, ".ssh", "known_hosts"), filepath.Join(home, ".ssh", "known_hosts2"))
+	}
+	p.hosts = knownhosts.New(append(hostFiles, p.knownHosts...)...)
+	opts = append(opts, proxy.WithHosts(p.hosts))
+	return opts, rules, nil
+}
+
 func clipServe() error {
 
 	clipCtx, clipCancel = context.WithCancel(context.Background())
@@ -274,11 +399,7 @@ func clipServe() error {
 	// we have possible clients for remote clipboard
 	clipHelp = fmt.Sprintf("gclpr is serving %d key(s) on port %d", len(pkeys), clipPort)
 	go func() {
-		lock := &locked
-		if ignorelock {
-			lock = nil // ignore session messages
-		}
-		if err := clip.Serve(clipCtx, clipPort, clipLE, pkeys, misc.GetMagic(), lock); err != nil {
+		if err := clip.Serve(clipCtx, clipPort, clipLE, pkeys, misc.GetMagic(), &locked); err != nil {
 			log.Printf("gclpr serve() returned error: %s", err.Error())
 			clipHelp = "gclpr is not served"
 		}
@@ -288,25 +409,50 @@ func clipServe() error {
 
 func main() {
 
-	util.NewLogWriter(title, 0, false)
+	logging.Install() // nothing is kept until arguments are known
+
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		util.AttachConsole()
+		os.Exit(ctlMain(os.Args[2:], os.Stdout, os.Stderr))
+	}
 
 	// Prepare help and parse arguments
 
+	def := config.Default()
+	cli.StringVar(&configPath, "config", defaultConfigPath(), "Configuration `file`, flags override its values")
 	cli.StringVar(&socketName, "socket", "", fmt.Sprintf("Auth socket `path` (max %d characters)", util.MaxNameLen))
 	cli.StringVar(&pipeName, "pipe", "", "Pipe `name` used by Windows ssh-agent.exe")
-	cli.StringVar(&envName, "envname", "SSH_AUTH_SOCK", "Environment variable `name` to hold socket path")
+	cli.Var(&backendURLs, "backend", "Agent backend `url`: npipe://./pipe/name, unix:path, tcp:host:port or memory: (repeatable, overrides pipe)")
+	cli.BoolVar(&compat, "compat", false, "Work around protocol features older agent lacks, like one shipped with Windows")
+	cli.BoolVar(&fallback, "fallback", false, "Use in-memory agent when backend is not available")
+	cli.StringVar(&logFile, "log-file", "", "Write log into `path`, it is rotated like audit file")
+	cli.TextVar(&logLevel, "log-level", def.Log.Level, "Minimal `level` of kept log messages: debug, info, warn or error")
+	cli.IntVar(&logSize, "log-size", def.Log.Size, "Rotate log file when it grows over `MB`")
+	cli.Var(&listenURLs, "listen", "Additional listener `url`: unix:path, tcp:127.0.0.1:port or npipe://./pipe/name, ?allow=patterns limits visible keys, ?readonly refuses key changes (repeatable)")
+	cli.StringVar(&confirm, "confirm", "", "Ask for confirmation before signing with keys matching `patterns` (\"*\" for all keys)")
+	cli.DurationVar(&confirmTime, "confirm-timeout", time.Duration(def.Confirm.Timeout), "Deny request when confirmation is not given within `duration`")
+	cli.StringVar(&askpass, "askpass", "", "SSH_ASKPASS style `program` to ask for confirmation instead of dialog box")
+	cli.BoolVar(&auditOn, "audit", false, "Record every agent request in %LOCALAPPDATA%\\wsl-ssh-agent\\audit.jsonl")
+	cli.IntVar(&auditSize, "audit-size", def.Audit.Size, "Rotate audit file when it grows over `MB`")
+	cli.Var(&knownHosts, "known-hosts", "Additional known_hosts `path` to name servers by their keys, e.g. \\\\wsl$\\Ubuntu\\home\\user\\.ssh\\known_hosts (repeatable)")
+	cli.Var(&signHosts, "sign-hosts", "Signing `rule` keys=hosts: keys matching patterns may only authenticate to hosts matching patterns (repeatable). Host names come from known_hosts files WSL could modify, use SHA256: host key fingerprints to pin hosts")
+	cli.StringVar(&noForward, "no-forwarding", "", "Refuse signing with keys matching `patterns` on forwarded agent connections")
+	cli.StringVar(&metricsAddr, "metrics", "", "Serve Prometheus metrics on loopback `address` host:port, e.g. 127.0.0.1:9464")
+	cli.StringVar(&envName, "envname", def.EnvName, "Environment variable `name` to hold socket path")
+	cli.BoolVar(&readOnly, "readonly", false, "Do not let clients of auth socket add or remove keys (use ?readonly for additional listeners)")
 	cli.BoolVar(&setenv, "setenv", false, "Export environment variable with 'envname' and modify WSLENV")
 	cli.BoolVar(&ignorelock, "nolock", false, "Provide access to ss-agent.exe even when user session is locked")
-	cli.IntVar(&clipPort, "port", 2850, "Remote clipboard port")
+	cli.Var(&onSessions, "on-session", "Session `rule` event=actions, e.g. remote-connect=deny-sign,notify (repeatable)")
+	cli.IntVar(&clipPort, "port", def.Clipboard.Port, "Remote clipboard port")
 	cli.StringVar(&clipLE, "line-endings", "", "Remote clipboard convert line endings (LF/CRLF)")
 	cli.BoolVar(&help, "help", false, "Show help")
-	cli.BoolVar(&debug, "debug", false, "Enable verbose debug logging")
+	cli.BoolVar(&debug, "debug", false, "Send log to debugger (OutputDebugString) and keep debug level messages unless -log-level is given")
 
 	// Build usage string
 	var buf strings.Builder
 	cli.SetOutput(&buf)
 	fmt.Fprintf(&buf, "\n%s\n\nVersion:\n\t%s (%s)\n\t%s\n\n", tooltip, misc.GetVersion(), runtime.Version(), misc.GetGitHash())
-	fmt.Fprintf(&buf, "Usage:\n\t%s [options]\n\nOptions:\n\n", title)
+	fmt.Fprintf(&buf, "Usage:\n\t%s [options]\n\t%s ctl [options] command\n\nOptions:\n\n", title, title)
 	cli.PrintDefaults()
 	usage = buf.String()
 
@@ -316,17 +462,64 @@ func main() {
 		util.ShowOKMessage(util.MsgError, title, err.Error())
 		os.Exit(1)
 	}
+	cli.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	cfg, err := loadConfig()
+	if err != nil {
+		util.ShowOKMessage(util.MsgError, title, err.Error())
+		os.Exit(1)
+	}
+	applyConfig(cfg)
+
 	cli.Usage = func() {
 		text := usage
+		if loaded.Load() != nil && len(configPath) > 0 {
+			text += fmt.Sprintf("\nConfiguration file:\n  %s", configPath)
+		}
 		if len(socketName) > 0 {
 			text += fmt.Sprintf("\nSocket path:\n  %s", socketName)
 		}
-		if len(pipeName) > 0 {
-			text += fmt.Sprintf("\nPipe name:\n  %s", pipeName)
+		if server != nil {
+			text += fmt.Sprintf("\nControl socket:\n  %s", controlPath(socketName))
+		}
+		if len(listeners) > 1 || readOnly {
+			text += "\nListeners:"
+			for _, ln := range listeners {
+				text += fmt.Sprintf("\n  %s", ln.Name())
+				if keys := proxy.Options(ln).Keys; keys != nil {
+					text += fmt.Sprintf(" (keys %s)", keys)
+				}
+				if proxy.Options(ln).ReadOnly {
+					text += " (read-only)"
+				}
+			}
+		}
+		if server != nil {
+			text += fmt.Sprintf("\nBackend:\n  %s", server.Backend().Name())
+		}
+		if hosts := current().hosts; hosts != nil {
+			text += "\nKnown hosts:"
+			for _, p := range hosts.Paths() {
+				text += fmt.Sprintf("\n  %s", p)
+			}
+		}
+		if sessions != nil {
+			text += fmt.Sprintf("\nSession rules:\n  %s", sessions.Rules())
+		}
+		if auditFile != nil {
+			text += fmt.Sprintf("\nAudit file:\n  %s", auditFile.Path())
+		}
+		if logOut != nil {
+			text += fmt.Sprintf("\nLog file:\n  %s", logOut.Path())
+		}
+		if len(metricsAddr) > 0 {
+			text += fmt.Sprintf("\nMetrics:\n  http://%s/metrics", metricsAddr)
 		}
 		if len(clipHelp) > 0 {
 			text += fmt.Sprintf("\nRemote clipboard:\n  %s", clipHelp)
 		}
+		if server != nil {
+			text += fmt.Sprintf("\nRejected requests:\n  %d", server.Stats().Rejected)
+		}
 		util.ShowOKMessage(util.MsgInformation, title, text)
 	}
 
@@ -335,7 +528,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	util.NewLogWriter(title, 0, debug)
+	if err := setupLogging(); err != nil {
+		util.ShowOKMessage(util.MsgError, title, err.Error())
+		os.Exit(1)
+	}
+	if logOut != nil {
+		defer logOut.Close()
+	}
 
 	// Check if Windows supports AF_UNIX sockets
 	if ok, err := util.IsProperWindowsVer(); err != nil {
@@ -350,7 +549,8 @@ func main() {
 	lockName := filepath.Join(os.TempDir(), title+".lock")
 	inst, err := si.CreateLockFile(lockName)
 	if err != nil {
-		log.Print("Application already running")
+		util.ShowOKMessage(util.MsgInformation, title,
+			fmt.Sprintf("Application is already running.\n\nUse \"%s ctl\" to control it, control socket:\n  %s", title, controlPath(socketName)))
 		os.Exit(0)
 	}
 	defer func() {
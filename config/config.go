@@ -0,0 +1,325 @@
+// Package config reads program configuration from TOML file.
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/proxy"
+	"wsl-ssh-agent/session"
+)
+
+// Config mirrors command line flags, flags given explicitly take precedence over it.
+type Config struct {
+	Socket     string     `toml:"socket"`
+	Pipe       string     `toml:"pipe"`
+	Backends   []string   `toml:"backends"`
+	Fallback   bool       `toml:"fallback"`
+	Compat     bool       `toml:"compat"`
+	EnvName    string     `toml:"envname"`
+	SetEnv     bool       `toml:"setenv"`
+	NoLock     bool       `toml:"nolock"`
+	ReadOnly   bool       `toml:"readonly"`
+	Debug      bool       `toml:"debug"`
+	KnownHosts []string   `toml:"known_hosts"`
+	Metrics    string     `toml:"metrics"` // loopback host:port to serve metrics on
+	Listeners  []Listener `toml:"listener"`
+
+	Confirm      Confirm     `toml:"confirm"`
+	SignHosts    []SignHosts `toml:"sign_hosts"`
+	NoForwarding Patterns    `toml:"no_forwarding"`
+	// Session holds actions by event name, events which are not mentioned keep default actions.
+	Session map[string][]session.Action `toml:"session"`
+
+	Audit     Audit     `toml:"audit"`
+	Log       Log       `toml:"log"`
+	Clipboard Clipboard `toml:"clipboard"`
+}
+
+// Listener is additional agent endpoint.
+type Listener struct {
+	URL      string     `toml:"url"`
+	Allow    []Patterns `toml:"allow"`
+	ReadOnly bool       `toml:"readonly"`
+	Token    string     `toml:"token"` // path of token file for tcp listeners
+}
+
+// String returns listener URL with parameters as proxy.Listen accepts it.
+func (l Listener) String() string {
+	q := url.Values{}
+	for _, p := range l.Allow {
+		q.Add("allow", string(p))
+	}
+	if l.ReadOnly {
+		q.Set("readonly", "true")
+	}
+	if len(l.Token) > 0 {
+		q.Set("token", l.Token)
+	}
+	if len(q) == 0 {
+		return l.URL
+	}
+	// proxy.Listen takes '+' literally, spaces (in comments and paths) must be percent encoded
+	return l.URL + "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// Confirm configures signing confirmation.
+type Confirm struct {
+	Keys    Patterns `toml:"keys"`
+	Timeout Duration `toml:"timeout"`
+	Askpass string   `toml:"askpass"`
+}
+
+// SignHosts limits hosts keys could authenticate to, see proxy.NewHostPolicy.
+type SignHosts struct {
+	Keys  string
+	Hosts []string
+}
+
+// UnmarshalTOML decodes and validates table with "keys" and "hosts".
+func (h *SignHosts) UnmarshalTOML(data any) error {
+	m, ok := data.(map[string]any)
+	if !ok {
+		return errors.New("sign_hosts must be a table")
+	}
+	for k, v := range m {
+		switch k {
+		case "keys":
+			if h.Keys, ok = v.(string); !ok {
+				return errors.New("sign_hosts keys must be a string")
+			}
+		case "hosts":
+			list, ok := v.([]any)
+			if !ok {
+				return errors.New("sign_hosts hosts must be an array of strings")
+			}
+			for _, x := range list {
+				s, ok := x.(string)
+				if !ok {
+					return errors.New("sign_hosts hosts must be an array of strings")
+				}
+				h.Hosts = append(h.Hosts, s)
+			}
+		default:
+			return fmt.Errorf("unknown sign_hosts key %q", k)
+		}
+	}
+	_, err := proxy.NewHostPolicy(h.String())
+	return err
+}
+
+// String returns rule in keys=hosts form.
+func (h SignHosts) String() string {
+	return h.Keys + "=" + strings.Join(h.Hosts, ",")
+}
+
+// Audit configures audit log.
+type Audit struct {
+	Enabled bool `toml:"enabled"`
+	Size    int  `toml:"size"` // MB
+}
+
+// Log configures logging, level could be changed without restart.
+type Log struct {
+	Level logging.Level `toml:"level"`
+	File  string        `toml:"file"`
+	Size  int           `toml:"size"` // MB
+}
+
+// Clipboard configures remote clipboard.
+type Clipboard struct {
+	Port        int    `toml:"port"`
+	LineEndings string `toml:"line_endings"`
+}
+
+// Patterns is comma separated list of key patterns, see proxy.KeyFilter.
+type Patterns string
+
+// UnmarshalText validates patterns.
+func (p *Patterns) UnmarshalText(text []byte) error {
+	if len(text) > 0 {
+		if _, err := proxy.NewKeyFilter(string(text)); err != nil {
+			return err
+		}
+	}
+	*p = Patterns(text)
+	return nil
+}
+
+// Duration is time.Duration written as string, like "30s".
+type Duration time.Duration
+
+// UnmarshalText parses duration.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return errors.New("duration must be positive")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Default returns configuration equal to defaults of command line flags.
+func Default() *Config {
+	return &Config{
+		EnvName:   "SSH_AUTH_SOCK",
+		Confirm:   Confirm{Timeout: Duration(proxy.DefaultApprovalTimeout)},
+		Audit:     Audit{Size: 10},
+		Log:       Log{Level: logging.LevelInfo, Size: 10},
+		Clipboard: Clipboard{Port: 2850},
+	}
+}
+
+// Error is configuration problem at particular line of the file.
+type Error struct {
+	Path string
+	Line int // 0 when unknown
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// Load reads configuration file over defaults.
+func Load(path string) (*Config, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(cfg)
+	if err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, &Error{Path: path, Line: pe.Position.Line, Msg: pe.Message}
+		}
+		return nil, decodeError(path, err)
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		return nil, &Error{Path: path, Line: keyLine(data, keys[0]), Msg: fmt.Sprintf("unknown key %q", keys[0].String())}
+	}
+
+	for name := range cfg.Session {
+		if !session.Event(name).Known() {
+			return nil, &Error{Path: path, Line: keyLine(data, toml.Key{"session", name}), Msg: fmt.Sprintf("unknown session event %q", name)}
+		}
+	}
+	for i, l := range cfg.Listeners {
+		if len(l.URL) == 0 {
+			return nil, &Error{Path: path, Line: tableLine(data, "listener", i), Msg: "listener without url"}
+		}
+	}
+	if cfg.Audit.Size <= 0 {
+		return nil, &Error{Path: path, Line: keyLine(data, toml.Key{"audit", "size"}), Msg: "audit size must be positive"}
+	}
+	if cfg.Log.Size <= 0 {
+		return nil, &Error{Path: path, Line: keyLine(data, toml.Key{"log", "size"}), Msg: "log size must be positive"}
+	}
+	if cfg.Clipboard.Port <= 0 || cfg.Clipboard.Port > 65535 {
+		return nil, &Error{Path: path, Line: keyLine(data, toml.Key{"clipboard", "port"}), Msg: "bad clipboard port"}
+	}
+	switch strings.ToUpper(cfg.Clipboard.LineEndings) {
+	case "", "LF", "CRLF":
+	default:
+		return nil, &Error{Path: path, Line: keyLine(data, toml.Key{"clipboard", "line_endings"}), Msg: "line endings must be LF or CRLF"}
+	}
+	return cfg, nil
+}
+
+// decodeError positions type mismatch errors, decoder reports them as plain text
+// "toml: line N (last key "k"): message".
+func decodeError(path string, err error) error {
+	e := &Error{Path: path, Msg: strings.TrimPrefix(err.Error(), "toml: ")}
+	if _, serr := fmt.Sscanf(e.Msg, "line %d", &e.Line); serr == nil {
+		if _, msg, ok := strings.Cut(e.Msg, "): "); ok {
+			e.Msg = msg
+		} else if _, msg, ok := strings.Cut(e.Msg, ": "); ok {
+			e.Msg = msg
+		}
+	}
+	return e
+}
+
+// keyLine finds line where key is defined, only simple "key = value" lines under table headers are recognized.
+func keyLine(data []byte, key toml.Key) int {
+	want := strings.Join(key, ".")
+	table := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			table = strings.Trim(strings.SplitN(line, "#", 2)[0], "[] \t")
+			continue
+		}
+		name, _, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name = strings.Trim(strings.TrimSpace(name), `"'`)
+		if len(table) > 0 {
+			name = table + "." + name
+		}
+		if name == want {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// tableLine finds header of n-th element of array of tables.
+func tableLine(data []byte, name string, n int) int {
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.Trim(strings.SplitN(strings.TrimSpace(line), "#", 2)[0], " \t") == "[["+name+"]]" {
+			if n == 0 {
+				return i + 1
+			}
+			n--
+		}
+	}
+	return 0
+}
+
+// Watch calls changed whenever file modification time or size changes until ctx is done. File which does not exist
+// yet is watched too.
+func Watch(ctx context.Context, path string, interval time.Duration, changed func()) {
+
+	stamp := func() string {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d/%d", fi.ModTime().UnixNano(), fi.Size())
+	}
+
+	last := stamp()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if cur := stamp(); cur != last {
+				last = cur
+				changed()
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"wsl-ssh-agent/logging"
+	"wsl-ssh-agent/proxy"
+	"wsl-ssh-agent/session"
+)
+
+func TestListenerRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "config test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		l    Listener
+	}{
+		{name: "spaces in comment", l: Listener{Allow: []Patterns{"*my laptop*", "!old key"}}},
+		{name: "fingerprint with plus", l: Listener{Allow: []Patterns{"SHA256:ab+cd/ef", "c-rsa"}, ReadOnly: true}},
+		{name: "token path with spaces", l: Listener{URL: "tcp:127.0.0.1:0", Token: filepath.Join(dir, "agent token"), ReadOnly: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.l
+			if len(l.URL) == 0 {
+				l.URL = "unix:" + filepath.Join(dir, "agent.sock")
+			}
+			ln, err := proxy.Listen(l.String())
+			if err != nil {
+				t.Fatalf("Listen(%q): %v", l.String(), err)
+			}
+			defer ln.Close()
+
+			opts := proxy.Options(ln)
+			if opts.ReadOnly != l.ReadOnly {
+				t.Errorf("read-only %t, want %t", opts.ReadOnly, l.ReadOnly)
+			}
+			if len(l.Allow) > 0 {
+				want, err := proxy.NewKeyFilter(string(l.Allow[0]), string(l.Allow[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if opts.Keys == nil || opts.Keys.String() != want.String() {
+					t.Errorf("keys %v, want %s", opts.Keys, want)
+				}
+			} else if opts.Keys != nil {
+				t.Errorf("keys %s, want none", opts.Keys)
+			}
+			if len(l.Token) > 0 {
+				if _, err := os.Stat(l.Token); err != nil {
+					t.Errorf("token file: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := `backends = ["npipe://./pipe/openssh-ssh-agent", "memory:"]
+nolock = true
+
+[[listener]]
+url = "unix:/tmp/work.sock"
+allow = ["*@work"]
+readonly = true
+
+[confirm]
+keys = "*"
+timeout = "10s"
+
+[[sign_hosts]]
+keys = "*@work"
+hosts = ["*.work.example.com"]
+
+[session]
+remote-connect = ["deny-sign", "notify"]
+
+[log]
+level = "debug"
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Backends) != 2 || !cfg.NoLock || cfg.EnvName != "SSH_AUTH_SOCK" {
+		t.Errorf("backends %q, nolock %t, envname %q", cfg.Backends, cfg.NoLock, cfg.EnvName)
+	}
+	if len(cfg.Listeners) != 1 || cfg.Listeners[0].String() != "unix:/tmp/work.sock?allow=%2A%40work&readonly=true" {
+		t.Errorf("listeners %+v", cfg.Listeners)
+	}
+	if cfg.Confirm.Keys != "*" || time.Duration(cfg.Confirm.Timeout) != 10*time.Second {
+		t.Errorf("confirm %+v", cfg.Confirm)
+	}
+	if len(cfg.SignHosts) != 1 || cfg.SignHosts[0].String() != "*@work=*.work.example.com" {
+		t.Errorf("sign hosts %+v", cfg.SignHosts)
+	}
+	if acts := cfg.Session["remote-connect"]; len(acts) != 2 || acts[0] != session.DenySign || acts[1] != session.Notify {
+		t.Errorf("session %+v", cfg.Session)
+	}
+	if cfg.Log.Level != logging.LevelDebug || cfg.Log.Size != 10 || cfg.Audit.Size != 10 {
+		t.Errorf("log %+v, audit %+v", cfg.Log, cfg.Audit)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		line int
+		msg  string
+	}{
+		{name: "syntax", data: "nolock = true\nsocket = \n", line: 2, msg: "expected value"},
+		{name: "type mismatch", data: "\nnolock = \"yes\"\n", line: 2, msg: "incompatible types"},
+		{name: "unknown key", data: "nolock = true\n\n[confirm]\nkey = \"*\"\n", line: 4, msg: `unknown key "confirm.key"`},
+		{name: "bad patterns", data: "no_forwarding = \"!\"\n", line: 1, msg: "negated"},
+		{name: "bad duration", data: "[confirm]\ntimeout = \"-1s\"\n", line: 2, msg: "positive"},
+		{name: "bad session event", data: "[session]\nsuspend = [\"deny-all\"]\n", line: 2, msg: `unknown session event "suspend"`},
+		{name: "bad session action", data: "[session]\nlock = [\"explode\"]\n", line: 2, msg: "explode"},
+		{name: "bad sign hosts", data: "[[sign_hosts]]\nkeys = \"*\"\nhost = [\"a\"]\n", line: 1, msg: "unknown sign_hosts key"},
+		{name: "listener without url", data: "[[listener]]\nurl = \"unix:/a\"\n\n[[listener]]\nreadonly = true\n", line: 4, msg: "without url"},
+		{name: "bad log level", data: "[log]\nlevel = \"loud\"\n", line: 2, msg: "loud"},
+		{name: "bad audit size", data: "[audit]\nsize = 0\n", line: 2, msg: "audit size"},
+		{name: "bad port", data: "\n\n[clipboard]\nport = 70000\n", line: 4, msg: "clipboard port"},
+		{name: "bad line endings", data: "[clipboard]\nline_endings = \"CR\"\n", line: 2, msg: "LF or CRLF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			var ce *Error
+			if !errors.As(err, &ce) {
+				t.Fatalf("Load error %v, want *Error", err)
+			}
+			if ce.Path != path || ce.Line != tt.line || !strings.Contains(ce.Msg, tt.msg) {
+				t.Errorf("Load error %q, want line %d with %q", err, tt.line, tt.msg)
+			}
+		})
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "config.toml")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load of missing file error %v, want not exist", err)
+	}
+}
@@ -0,0 +1,333 @@
+// Package control implements JSON protocol used to drive running program from scripts.
+//
+// Client starts with line holding token server wrote next to the socket, only user able to read it could drive the
+// program. Then it sends requests as JSON objects, one per line, and reads response line after each of them. Stream
+// commands answer with response line per result until client disconnects or error response ends the stream.
+package control
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/logging"
+)
+
+const (
+	tokenLen     = 32
+	tokenTimeout = 5 * time.Second
+)
+
+// Request asks server to run command.
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// Response is command outcome.
+type Response struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Handler runs command, result is marshaled into response.
+type Handler func(args json.RawMessage) (any, error)
+
+// StreamHandler runs stream command, every result is passed to send. It returns when done is closed (client went
+// away) or when it could not continue.
+type StreamHandler func(args json.RawMessage, send func(result any) error, done <-chan struct{}) error
+
+// Server dispatches requests to registered handlers.
+type Server struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	streams  map[string]StreamHandler
+}
+
+// NewServer creates server without commands.
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]Handler), streams: make(map[string]StreamHandler)}
+}
+
+// Handle registers handler for command.
+func (s *Server) Handle(command string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// HandleStream registers handler for stream command.
+func (s *Server) HandleStream(command string, h StreamHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[command] = h
+}
+
+// Commands returns names of registered commands.
+func (s *Server) Commands() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]string, 0, len(s.handlers)+len(s.streams))
+	for name := range s.handlers {
+		list = append(list, name)
+	}
+	for name := range s.streams {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// TokenPath returns name of the file holding token of control socket at path.
+func TokenPath(path string) string {
+	return path + ".token"
+}
+
+type listener struct {
+	net.Listener
+	token []byte
+	path  string
+}
+
+func (l *listener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(TokenPath(l.path))
+	return err
+}
+
+// Listen creates unix socket at path together with token file clients have to present, stale files left by previous
+// run are removed. Socket is not created when token could not be written.
+func Listen(path string) (net.Listener, error) {
+	for _, name := range []string{path, TokenPath(path)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to remove stale control file: %w", err)
+		}
+	}
+	token := make([]byte, tokenLen)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("unable to generate control token: %w", err)
+	}
+	token = []byte(hex.EncodeToString(token))
+	if err := os.WriteFile(TokenPath(path), token, 0600); err != nil {
+		return nil, fmt.Errorf("unable to write control token: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		_ = os.Remove(TokenPath(path))
+		return nil, fmt.Errorf("unable to listen on control socket: %w", err)
+	}
+	return &listener{Listener: ln, token: token, path: path}, nil
+}
+
+// Serve accepts connections until listener, which has to be created by Listen, is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+	l, ok := ln.(*listener)
+	if !ok {
+		return errors.New("control listener is not protected by token")
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("control accept error: %w", err)
+		}
+		go s.handle(conn, l.token)
+	}
+}
+
+// authenticate checks token client starts with.
+func authenticate(conn net.Conn, in *bufio.Scanner, token []byte) error {
+	_ = conn.SetReadDeadline(time.Now().Add(tokenTimeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	if !in.Scan() {
+		return errors.New("no control token")
+	}
+	if subtle.ConstantTimeCompare(in.Bytes(), token) != 1 {
+		return errors.New("bad control token")
+	}
+	return nil
+}
+
+func (s *Server) handle(conn net.Conn, token []byte) {
+
+	defer conn.Close()
+
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, 1<<20)
+	out := json.NewEncoder(conn)
+	if err := authenticate(conn, in, token); err != nil {
+		logging.Warnf("Control connection refused: %s", err)
+		_ = out.Encode(&Response{Error: err.Error()})
+		return
+	}
+	for in.Scan() {
+		var req Request
+		res := &Response{}
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			res.Error = fmt.Sprintf("bad request: %s", err)
+		} else if h := s.stream(req.Command); h != nil {
+			s.runStream(h, &req, conn, in, out)
+			return
+		} else {
+			s.run(&req, res)
+		}
+		if err := out.Encode(res); err != nil {
+			logging.Warnf("Control write error: %s", err)
+			return
+		}
+	}
+}
+
+func (s *Server) run(req *Request, res *Response) {
+
+	s.mu.RLock()
+	h, ok := s.handlers[req.Command]
+	s.mu.RUnlock()
+	if !ok {
+		res.Error = fmt.Sprintf("unknown command %q", req.Command)
+		return
+	}
+	logging.Debugf("Control command %q", req.Command)
+
+	result, err := h(req.Args)
+	if err != nil {
+		res.Error = err.Error()
+		return
+	}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			res.Error = fmt.Sprintf("unable to encode result: %s", err)
+			return
+		}
+		res.Result = data
+	}
+	res.OK = true
+}
+
+func (s *Server) stream(command string) StreamHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.streams[command]
+}
+
+// runStream serves stream command, connection is not used for anything else afterwards.
+func (s *Server) runStream(h StreamHandler, req *Request, conn net.Conn, in *bufio.Scanner, out *json.Encoder) {
+
+	logging.Debugf("Control stream %q", req.Command)
+
+	done := make(chan struct{})
+	go func() {
+		// client is not expected to send anything, it just disconnects
+		for in.Scan() {
+		}
+		close(done)
+	}()
+
+	send := func(result any) error {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		return out.Encode(&Response{OK: true, Result: data})
+	}
+	if err := h(req.Args, send, done); err != nil {
+		_ = out.Encode(&Response{Error: err.Error()})
+	}
+	logging.Debugf("Control stream %q ended", req.Command)
+}
+
+// Client talks to control server.
+type Client struct {
+	conn net.Conn
+	in   *bufio.Scanner
+}
+
+// Dial connects to control socket at path presenting token from the file next to it.
+func Dial(path string) (*Client, error) {
+	token, err := os.ReadFile(TokenPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read control token: %w", err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to control socket: %w", err)
+	}
+	if _, err := conn.Write(append(token, '\n')); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to send control token: %w", err)
+	}
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, 16<<20)
+	return &Client{conn: conn, in: in}, nil
+}
+
+// Close closes connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call runs command with args (nil for none) and returns raw result.
+func (c *Client) Call(command string, args any) (json.RawMessage, error) {
+	if err := c.send(command, args); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+// Stream runs stream command and passes results to recv until stream ends or recv fails.
+func (c *Client) Stream(command string, args any, recv func(result json.RawMessage) error) error {
+
+	if err := c.send(command, args); err != nil {
+		return err
+	}
+	for {
+		res, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if err := recv(res); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) send(command string, args any) error {
+	req := Request{Command: command}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = data
+	}
+	return json.NewEncoder(c.conn).Encode(&req)
+}
+
+func (c *Client) receive() (json.RawMessage, error) {
+	if !c.in.Scan() {
+		if err := c.in.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("control connection closed")
+	}
+	var res Response
+	if err := json.Unmarshal(c.in.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("bad response: %w", err)
+	}
+	if !res.OK {
+		return nil, errors.New(res.Error)
+	}
+	return res.Result, nil
+}
@@ -0,0 +1,107 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	// t.TempDir may exceed unix socket path limit
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "test.ctl")
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return path
+}
+
+func TestToken(t *testing.T) {
+	ran := 0
+	s := NewServer()
+	s.Handle("ping", func(json.RawMessage) (any, error) {
+		ran++
+		return "pong", nil
+	})
+	path := serve(t, s)
+
+	if fi, err := os.Stat(TokenPath(path)); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("token file %v, %v", fi, err)
+	}
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Call("ping", nil)
+	c.Close()
+	if err != nil || string(res) != `"pong"` {
+		t.Fatalf("ping = %s, %v", res, err)
+	}
+
+	for _, first := range []string{"", "bad token", `{"command":"ping"}`} {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(first + "\n" + `{"command":"ping"}` + "\n"))
+		in := bufio.NewScanner(conn)
+		var reply Response
+		if !in.Scan() || json.Unmarshal(in.Bytes(), &reply) != nil || reply.OK || reply.Error != "bad control token" {
+			t.Errorf("reply to %q %+v, want bad token", first, reply)
+		}
+		if in.Scan() {
+			t.Errorf("connection with %q is served", first)
+		}
+		conn.Close()
+	}
+	if ran != 1 {
+		t.Errorf("command ran %d times, want 1", ran)
+	}
+}
+
+func TestServeUnprotected(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ln, err := net.Listen("unix", filepath.Join(dir, "plain.ctl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer().Serve(ln); err == nil {
+		t.Error("Serve accepted listener without token")
+	}
+}
+
+func TestListenUnwritableToken(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.ctl")
+	if err := os.Mkdir(TokenPath(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(TokenPath(path), "keep"), nil, 0600)
+	if ln, err := Listen(path); err == nil {
+		ln.Close()
+		t.Fatal("Listen succeeded without token file")
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("control socket was created without token")
+	}
+}
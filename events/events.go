@@ -0,0 +1,117 @@
+// Package events delivers live notifications about agent activity to subscribers.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/logging"
+)
+
+// Type names what happened.
+type Type string
+
+// Event types.
+const (
+	ConnectionOpened Type = "connection-opened"
+	ConnectionClosed Type = "connection-closed"
+	SignRequested    Type = "sign-requested"
+	SignApproved     Type = "sign-approved"
+	SignDenied       Type = "sign-denied"
+	SessionLock      Type = "session-lock"
+	SessionUnlock    Type = "session-unlock"
+	BackendUp        Type = "backend-up"
+	BackendDown      Type = "backend-down"
+	ConfigReloaded   Type = "config-reloaded"
+)
+
+// Event describes single occurrence, only fields relevant to its type are set.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       Type      `json:"type"`
+	Connection uint64    `json:"connection,omitempty"` // ID of client connection
+	Listener   string    `json:"listener,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	Key        string    `json:"key,omitempty"`  // fingerprint of the key
+	Host       string    `json:"host,omitempty"` // names of the server key authenticates to
+	Backend    string    `json:"backend,omitempty"`
+	Reason     string    `json:"reason,omitempty"` // why sign was denied or backend is down
+	Config     string    `json:"config,omitempty"`
+}
+
+// Sink receives events. Implementations must be safe for concurrent use and must not block.
+type Sink interface {
+	Publish(e *Event)
+}
+
+// subscriberQueue is how many events could wait for slow subscriber before it is dropped.
+const subscriberQueue = 256
+
+// Bus is Sink which fans events out to subscribers.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives events published after it was made.
+type Subscription struct {
+	bus  *Bus
+	c    chan *Event
+	lost bool
+}
+
+// NewBus creates bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish sends event to all subscribers, time is set when it is zero. Subscriber which does not keep up loses its
+// subscription.
+func (b *Bus) Publish(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.c <- e:
+		default:
+			logging.Warnf("Dropping slow event subscriber")
+			sub.lost = true
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe starts delivering events to new subscription.
+func (b *Bus) Subscribe() *Subscription {
+	sub := &Subscription{bus: b, c: make(chan *Event, subscriberQueue)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Events returns channel of events, it is closed when subscription ends.
+func (sub *Subscription) Events() <-chan *Event {
+	return sub.c
+}
+
+// Lost reports if subscription was ended because subscriber did not keep up.
+func (sub *Subscription) Lost() bool {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	return sub.lost
+}
+
+// Close ends subscription.
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+	if _, ok := sub.bus.subs[sub]; ok {
+		delete(sub.bus.subs, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	b.Publish(&Event{Type: BackendDown}) // nobody listens
+
+	first, second := b.Subscribe(), b.Subscribe()
+	when := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	b.Publish(&Event{Type: SessionLock, Time: when})
+	b.Publish(&Event{Type: ConnectionOpened, Connection: 7})
+
+	for i, sub := range []*Subscription{first, second} {
+		lock, opened := <-sub.Events(), <-sub.Events()
+		if lock.Type != SessionLock || !lock.Time.Equal(when) {
+			t.Errorf("subscriber %d got %+v, want session lock at %s", i, lock, when)
+		}
+		if opened.Type != ConnectionOpened || opened.Connection != 7 || opened.Time.IsZero() {
+			t.Errorf("subscriber %d got %+v, want timed connection 7 opening", i, opened)
+		}
+	}
+
+	first.Close()
+	first.Close() // twice is fine
+	if _, ok := <-first.Events(); ok {
+		t.Error("channel is open after Close")
+	}
+	b.Publish(&Event{Type: ConfigReloaded})
+	if e := <-second.Events(); e.Type != ConfigReloaded {
+		t.Errorf("remaining subscriber got %s, want %s", e.Type, ConfigReloaded)
+	}
+	if first.Lost() || second.Lost() {
+		t.Error("subscription is lost")
+	}
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	b := NewBus()
+	sub := b.Subscribe()
+	for i := 0; i <= subscriberQueue; i++ {
+		b.Publish(&Event{Type: SignRequested})
+	}
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	if n != subscriberQueue || !sub.Lost() {
+		t.Errorf("slow subscriber got %d events, lost %t, want %d and lost", n, sub.Lost(), subscriberQueue)
+	}
+	sub.Close() // after bus dropped it
+}
@@ -0,0 +1,192 @@
+// Package keyring implements in-process ssh-agent holding private keys in memory.
+package keyring
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"log"
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// Keyring is in-memory ssh-agent. It is safe for concurrent use.
+type Keyring struct {
+	mu         sync.Mutex
+	keys       []*key
+	passphrase []byte // hash of lock passphrase when locked
+}
+
+// New returns empty Keyring.
+func New() *Keyring {
+	return &Keyring{}
+}
+
+// Handle processes single agent request and returns reply.
+func (k *Keyring) Handle(req agentproto.Message) agentproto.Message {
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch m := req.(type) {
+	case *agentproto.RequestIdentities:
+		return k.list()
+	case *agentproto.SignRequest:
+		return k.sign(m)
+	case *agentproto.AddIdentity:
+		return k.add(m)
+	case *agentproto.RemoveIdentity:
+		return k.remove(m.KeyBlob)
+	case *agentproto.RemoveAllIdentities:
+		if k.passphrase != nil {
+			return &agentproto.Failure{}
+		}
+		k.clear()
+		return &agentproto.Success{}
+	case *agentproto.Lock:
+		return k.lock(m.Passphrase)
+	case *agentproto.Unlock:
+		return k.unlock(m.Passphrase)
+	case *agentproto.Extension:
+		if m.Name == agentproto.ExtQuery {
+			return agentproto.QueryResponse([]string{agentproto.ExtQuery})
+		}
+		return &agentproto.ExtensionFailure{}
+	}
+	return &agentproto.Failure{}
+}
+
+// Clear removes all keys from keyring, even when it is locked with passphrase.
+func (k *Keyring) Clear() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.clear()
+}
+
+func (k *Keyring) clear() {
+	for _, key := range k.keys {
+		key.stop()
+	}
+	k.keys = nil
+}
+
+// Len returns number of keys in keyring.
+func (k *Keyring) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.keys)
+}
+
+func (k *Keyring) find(blob []byte) int {
+	for i, key := range k.keys {
+		if bytes.Equal(key.blob, blob) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (k *Keyring) list() agentproto.Message {
+	res := &agentproto.IdentitiesAnswer{}
+	if k.passphrase != nil {
+		// locked agent pretends to be empty
+		return res
+	}
+	for _, key := range k.keys {
+		res.Keys = append(res.Keys, agentproto.Identity{KeyBlob: key.blob, Comment: key.comment})
+	}
+	return res
+}
+
+func (k *Keyring) sign(m *agentproto.SignRequest) agentproto.Message {
+	if k.passphrase != nil {
+		return &agentproto.Failure{}
+	}
+	i := k.find(m.KeyBlob)
+	if i < 0 {
+		return &agentproto.Failure{}
+	}
+	sig, err := k.keys[i].sign(m.Data, m.Flags)
+	if err != nil {
+		logging.Warnf("Keyring unable to sign: %s", err)
+		return &agentproto.Failure{}
+	}
+	return &agentproto.SignResponse{Signature: sig}
+}
+
+func (k *Keyring) add(m *agentproto.AddIdentity) agentproto.Message {
+	if k.passphrase != nil {
+		return &agentproto.Failure{}
+	}
+	var lifetime time.Duration
+	for _, c := range m.Constraints {
+		lc, ok := c.(agentproto.LifetimeConstraint)
+		if !ok {
+			log.Printf("Keyring does not support key constraint %d", c.Type())
+			return &agentproto.Failure{}
+		}
+		lifetime = time.Duration(lc.Seconds) * time.Second
+	}
+	key, err := newKey(m)
+	if err != nil {
+		logging.Warnf("Keyring unable to add key: %s", err)
+		return &agentproto.Failure{}
+	}
+	if i := k.find(key.blob); i >= 0 {
+		k.keys[i].stop()
+		k.keys[i] = key
+	} else {
+		k.keys = append(k.keys, key)
+	}
+	if lifetime > 0 {
+		key.expire = time.AfterFunc(lifetime, func() { k.expire(key) })
+	}
+	return &agentproto.Success{}
+}
+
+func (k *Keyring) remove(blob []byte) agentproto.Message {
+	if k.passphrase != nil {
+		return &agentproto.Failure{}
+	}
+	i := k.find(blob)
+	if i < 0 {
+		return &agentproto.Failure{}
+	}
+	k.keys[i].stop()
+	k.keys = append(k.keys[:i], k.keys[i+1:]...)
+	return &agentproto.Success{}
+}
+
+// expire removes key when its lifetime is over unless it was already removed or replaced.
+func (k *Keyring) expire(key *key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i, kk := range k.keys {
+		if kk == key {
+			log.Printf("Keyring key %q expired", key.comment)
+			k.keys = append(k.keys[:i], k.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func (k *Keyring) lock(passphrase []byte) agentproto.Message {
+	if k.passphrase != nil {
+		return &agentproto.Failure{}
+	}
+	h := sha512.Sum512(passphrase)
+	k.passphrase = h[:]
+	return &agentproto.Success{}
+}
+
+func (k *Keyring) unlock(passphrase []byte) agentproto.Message {
+	h := sha512.Sum512(passphrase)
+	if k.passphrase == nil || subtle.ConstantTimeCompare(h[:], k.passphrase) != 1 {
+		return &agentproto.Failure{}
+	}
+	k.passphrase = nil
+	return &agentproto.Success{}
+}
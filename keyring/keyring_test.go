@@ -0,0 +1,216 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+func ed25519Identity(t *testing.T, comment string) *agentproto.AddIdentity {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &agentproto.AddIdentity{KeyType: "ssh-ed25519", Key: agentproto.AppendBytes(agentproto.AppendBytes(nil, pub), priv), Comment: comment}
+}
+
+func ecdsaIdentity(t *testing.T, comment string) *agentproto.AddIdentity {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := k.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := agentproto.AppendBytes(agentproto.AppendString(nil, "nistp256"), q)
+	return &agentproto.AddIdentity{KeyType: "ecdsa-sha2-nistp256", Key: agentproto.AppendMpint(b, k.D), Comment: comment}
+}
+
+func rsaIdentity(t *testing.T, comment string) *agentproto.AddIdentity {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	for _, v := range []*big.Int{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]} {
+		b = agentproto.AppendMpint(b, v)
+	}
+	return &agentproto.AddIdentity{KeyType: "ssh-rsa", Key: b, Comment: comment}
+}
+
+func publicKey(t *testing.T, m *agentproto.AddIdentity) []byte {
+	t.Helper()
+	blob, err := m.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func succeeded(m agentproto.Message) bool {
+	_, ok := m.(*agentproto.Success)
+	return ok
+}
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    func(*testing.T, string) *agentproto.AddIdentity
+		flags  agentproto.SignFlags
+		format string
+	}{
+		{name: "ed25519", key: ed25519Identity, format: "ssh-ed25519"},
+		{name: "ecdsa", key: ecdsaIdentity, format: "ecdsa-sha2-nistp256"},
+		{name: "rsa sha1", key: rsaIdentity, format: "ssh-rsa"},
+		{name: "rsa sha256", key: rsaIdentity, flags: agentproto.SignRSASHA256, format: "rsa-sha2-256"},
+		{name: "rsa sha512", key: rsaIdentity, flags: agentproto.SignRSASHA512, format: "rsa-sha2-512"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := New()
+			add := tt.key(t, tt.name)
+			if got := k.Handle(add); !succeeded(got) {
+				t.Fatalf("add reply %s", got.Type())
+			}
+			blob := publicKey(t, add)
+			data := []byte("data to sign")
+			res, ok := k.Handle(&agentproto.SignRequest{KeyBlob: blob, Data: data, Flags: tt.flags}).(*agentproto.SignResponse)
+			if !ok {
+				t.Fatal("sign failed")
+			}
+			if format := agentproto.NewReader(res.Signature).Text(); format != tt.format {
+				t.Errorf("signature format %q, want %q", format, tt.format)
+			}
+			if err := agentproto.Verify(blob, data, res.Signature); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestBadKeys(t *testing.T) {
+	mismatched := ecdsaIdentity(t, "mismatched")
+	other := ecdsaIdentity(t, "other")
+	// public point of one key with private scalar of another
+	f, _ := mismatched.KeyFields()
+	g, _ := other.KeyFields()
+	mismatched.Key = agentproto.AppendBytes(agentproto.AppendBytes(agentproto.AppendBytes(nil, f[0]), f[1]), g[2])
+
+	short := ed25519Identity(t, "short")
+	f, _ = short.KeyFields()
+	short.Key = agentproto.AppendBytes(agentproto.AppendBytes(nil, f[0]), f[1][:32])
+
+	// public key of one ed25519 key with private key of another
+	foreign := ed25519Identity(t, "foreign")
+	f, _ = foreign.KeyFields()
+	g, _ = ed25519Identity(t, "other").KeyFields()
+	foreign.Key = agentproto.AppendBytes(agentproto.AppendBytes(nil, f[0]), g[1])
+
+	tests := []struct {
+		name string
+		key  *agentproto.AddIdentity
+	}{
+		{name: "ecdsa public point mismatch", key: mismatched},
+		{name: "short ed25519 key", key: short},
+		{name: "ed25519 public key mismatch", key: foreign},
+		{name: "unsupported type", key: &agentproto.AddIdentity{KeyType: "ssh-dss", Key: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := New()
+			if got := k.Handle(tt.key); succeeded(got) {
+				t.Error("bad key was added")
+			}
+			if k.Len() != 0 {
+				t.Errorf("keyring holds %d keys", k.Len())
+			}
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	k := New()
+	add := ed25519Identity(t, "c-ed25519")
+	k.Handle(add)
+
+	if !succeeded(k.Handle(&agentproto.Lock{Passphrase: []byte("secret")})) {
+		t.Fatal("lock failed")
+	}
+	if list := k.Handle(&agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer); len(list.Keys) != 0 {
+		t.Errorf("locked keyring lists %d keys", len(list.Keys))
+	}
+	for _, req := range []agentproto.Message{
+		&agentproto.SignRequest{KeyBlob: publicKey(t, add), Data: []byte("data")},
+		ed25519Identity(t, "other"),
+		&agentproto.RemoveAllIdentities{},
+		&agentproto.Lock{Passphrase: []byte("secret")},
+		&agentproto.Unlock{Passphrase: []byte("wrong")},
+	} {
+		if got := k.Handle(req); got.Type() != agentproto.AgentFailure {
+			t.Errorf("%s to locked keyring reply %s", req.Type(), got.Type())
+		}
+	}
+	if !succeeded(k.Handle(&agentproto.Unlock{Passphrase: []byte("secret")})) {
+		t.Fatal("unlock failed")
+	}
+	if list := k.Handle(&agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer); len(list.Keys) != 1 || list.Keys[0].Comment != "c-ed25519" {
+		t.Errorf("unlocked keyring lists %+v", list.Keys)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	k := New()
+	first, second := ed25519Identity(t, "first"), ed25519Identity(t, "second")
+	k.Handle(first)
+	k.Handle(second)
+	k.Handle(first) // replaces
+
+	if k.Len() != 2 {
+		t.Fatalf("keyring holds %d keys, want 2", k.Len())
+	}
+	if !succeeded(k.Handle(&agentproto.RemoveIdentity{KeyBlob: publicKey(t, first)})) {
+		t.Error("remove failed")
+	}
+	if succeeded(k.Handle(&agentproto.RemoveIdentity{KeyBlob: publicKey(t, first)})) {
+		t.Error("second removal succeeded")
+	}
+	if !succeeded(k.Handle(&agentproto.RemoveAllIdentities{})) || k.Len() != 0 {
+		t.Errorf("remove all left %d keys", k.Len())
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	k := New()
+	expiring := ed25519Identity(t, "expiring")
+	expiring.Constrained = true
+	expiring.Constraints = []agentproto.Constraint{agentproto.LifetimeConstraint{Seconds: 1}}
+	k.Handle(expiring)
+	k.Handle(ed25519Identity(t, "kept"))
+
+	confirm := ed25519Identity(t, "confirm")
+	confirm.Constrained = true
+	confirm.Constraints = []agentproto.Constraint{agentproto.ConfirmConstraint{}}
+	if succeeded(k.Handle(confirm)) {
+		t.Error("key with unsupported constraint was added")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for k.Len() > 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	list := k.Handle(&agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer)
+	if len(list.Keys) != 1 || list.Keys[0].Comment != "kept" {
+		t.Errorf("keys after expiry %+v, want kept", list.Keys)
+	}
+}
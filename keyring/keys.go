@@ -0,0 +1,195 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+// key is private key held by keyring together with its wire encoded public part.
+type key struct {
+	blob    []byte
+	comment string
+	signer  crypto.Signer
+	expire  *time.Timer // removes key when lifetime constraint runs out
+}
+
+var curves = map[string]elliptic.Curve{
+	"nistp256": elliptic.P256(),
+	"nistp384": elliptic.P384(),
+	"nistp521": elliptic.P521(),
+}
+
+// newKey builds private key from ADD_IDENTITY request.
+func newKey(m *agentproto.AddIdentity) (*key, error) {
+
+	f, err := m.KeyFields()
+	if err != nil {
+		return nil, err
+	}
+
+	k := &key{comment: m.Comment}
+	switch m.KeyType {
+	case "ssh-ed25519":
+		k.blob = ed25519Blob(f[0])
+		k.signer, err = ed25519Key(f[0], f[1])
+	case "ssh-ed25519-cert-v01@openssh.com":
+		k.blob = f[0]
+		k.signer, err = ed25519Key(f[1], f[2])
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		k.blob = ecdsaBlob(m.KeyType, f[0], f[1])
+		k.signer, err = ecdsaKey(string(f[0]), f[1], f[2])
+	case "ecdsa-sha2-nistp256-cert-v01@openssh.com", "ecdsa-sha2-nistp384-cert-v01@openssh.com", "ecdsa-sha2-nistp521-cert-v01@openssh.com":
+		k.blob = f[0]
+		r := certReader(f[0])
+		curve, q := r.Text(), r.Bytes()
+		if err = r.Err(); err == nil {
+			k.signer, err = ecdsaKey(curve, q, f[1])
+		}
+	case "ssh-rsa":
+		k.blob = rsaBlob(f[1], f[0])
+		k.signer, err = rsaKey(f[0], f[1], f[2], f[4], f[5])
+	case "ssh-rsa-cert-v01@openssh.com":
+		k.blob = f[0]
+		r := certReader(f[0])
+		e, n := r.Bytes(), r.Bytes()
+		if err = r.Err(); err == nil {
+			k.signer, err = rsaKey(n, e, f[1], f[3], f[4])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", m.KeyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad %s key: %w", m.KeyType, err)
+	}
+	return k, nil
+}
+
+func (k *key) stop() {
+	if k.expire != nil {
+		k.expire.Stop()
+	}
+}
+
+// certReader positions reader on certified public key fields, right after certificate type and nonce.
+func certReader(cert []byte) *agentproto.Reader {
+	r := agentproto.NewReader(cert)
+	r.Text()
+	r.Bytes()
+	return r
+}
+
+func ed25519Blob(pub []byte) []byte {
+	return agentproto.AppendBytes(agentproto.AppendString(nil, "ssh-ed25519"), pub)
+}
+
+func ed25519Key(pub, priv []byte) (crypto.Signer, error) {
+	if len(pub) != ed25519.PublicKeySize || len(priv) != ed25519.PrivateKeySize {
+		return nil, errors.New("bad key length")
+	}
+	// private key carries its public half, which is what signatures are made with
+	if !bytes.Equal(pub, priv[ed25519.SeedSize:]) {
+		return nil, errors.New("public key does not match private key")
+	}
+	return ed25519.PrivateKey(priv), nil
+}
+
+func ecdsaBlob(keyType string, curve, q []byte) []byte {
+	b := agentproto.AppendString(nil, keyType)
+	b = agentproto.AppendBytes(b, curve)
+	return agentproto.AppendBytes(b, q)
+}
+
+func ecdsaKey(curveName string, q, d []byte) (crypto.Signer, error) {
+	curve, ok := curves[curveName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q", curveName)
+	}
+	// mpint may carry leading zero or be shorter than curve size
+	raw := new(big.Int).SetBytes(d).FillBytes(make([]byte, (curve.Params().BitSize+7)/8))
+	k, err := ecdsa.ParseRawPrivateKey(curve, raw)
+	if err != nil {
+		return nil, err
+	}
+	if pub, err := k.PublicKey.Bytes(); err != nil || !bytes.Equal(pub, q) {
+		return nil, errors.New("public point does not match private key")
+	}
+	return k, nil
+}
+
+func rsaBlob(e, n []byte) []byte {
+	b := agentproto.AppendString(nil, "ssh-rsa")
+	b = agentproto.AppendMpint(b, new(big.Int).SetBytes(e))
+	return agentproto.AppendMpint(b, new(big.Int).SetBytes(n))
+}
+
+func rsaKey(n, e, d, p, q []byte) (crypto.Signer, error) {
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("bad public exponent")
+	}
+	k := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())},
+		D:         new(big.Int).SetBytes(d),
+		Primes:    []*big.Int{new(big.Int).SetBytes(p), new(big.Int).SetBytes(q)},
+	}
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	k.Precompute()
+	return k, nil
+}
+
+// sign produces wire encoded ssh signature of data.
+func (k *key) sign(data []byte, flags agentproto.SignFlags) ([]byte, error) {
+
+	var (
+		format string
+		blob   []byte
+	)
+	switch s := k.signer.(type) {
+	case ed25519.PrivateKey:
+		format, blob = "ssh-ed25519", ed25519.Sign(s, data)
+	case *ecdsa.PrivateKey:
+		var h crypto.Hash
+		switch s.Curve.Params().BitSize {
+		case 256:
+			format, h = "ecdsa-sha2-nistp256", crypto.SHA256
+		case 384:
+			format, h = "ecdsa-sha2-nistp384", crypto.SHA384
+		default:
+			format, h = "ecdsa-sha2-nistp521", crypto.SHA512
+		}
+		r, ss, err := ecdsa.Sign(rand.Reader, s, agentproto.Digest(h, data))
+		if err != nil {
+			return nil, err
+		}
+		blob = agentproto.AppendMpint(agentproto.AppendMpint(nil, r), ss)
+	case *rsa.PrivateKey:
+		h := crypto.SHA1
+		format = "ssh-rsa"
+		switch {
+		case flags&agentproto.SignRSASHA512 != 0:
+			format, h = "rsa-sha2-512", crypto.SHA512
+		case flags&agentproto.SignRSASHA256 != 0:
+			format, h = "rsa-sha2-256", crypto.SHA256
+		}
+		var err error
+		if blob, err = rsa.SignPKCS1v15(rand.Reader, s, h, agentproto.Digest(h, data)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signer %T", k.signer)
+	}
+	return agentproto.AppendBytes(agentproto.AppendString(nil, format), blob), nil
+}
@@ -0,0 +1,325 @@
+// Package knownhosts finds host names for server host keys in OpenSSH known_hosts files.
+package knownhosts
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+const (
+	markerCA      = "@cert-authority"
+	markerRevoked = "@revoked"
+	hashPrefix    = "|1|"
+)
+
+type hashed struct {
+	salt, hash []byte
+}
+
+// entry is single known_hosts line.
+type entry struct {
+	marker   string
+	patterns []string // plain host patterns, negated ones excluded
+	hashed   []hashed
+	key      []byte
+}
+
+type file struct {
+	mtime   time.Time
+	entries []entry
+	names   []string // host names mentioned in file and ssh config next to it, used to resolve hashed entries
+	ports   []string // non-standard ports mentioned there, hashed entries of such hosts are hashed as [host]:port
+}
+
+// DB looks host keys up in a set of known_hosts files. Files are re-read when they change. It is safe for concurrent
+// use.
+type DB struct {
+	paths []string
+
+	mu    sync.Mutex
+	files map[string]*file
+}
+
+// New returns DB for known_hosts files. Missing files are ignored.
+func New(paths ...string) *DB {
+	return &DB{paths: paths, files: make(map[string]*file)}
+}
+
+// Paths returns known_hosts files DB uses.
+func (db *DB) Paths() []string {
+	return db.paths
+}
+
+// Lookup returns sorted host names known to use host key, certificates are resolved through @cert-authority entries.
+// Names of hashed entries could only be found when they are mentioned in plain entries or ssh config files, hosts on
+// non-standard ports are named [host]:port as in known_hosts. Revoked keys have no names.
+func (db *DB) Lookup(hostKey []byte) []string {
+
+	key, err := agentproto.PlainKey(hostKey)
+	if err != nil {
+		return nil
+	}
+	var cert *agentproto.Certificate
+	if agentproto.IsCertificate(hostKey) {
+		if cert, err = agentproto.ParseCertificate(hostKey); err != nil || cert.CheckSignature() != nil {
+			cert = nil
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	files := db.load()
+	var candidates, ports []string
+	for _, f := range files {
+		candidates = append(candidates, f.names...)
+		ports = append(ports, f.ports...)
+	}
+
+	found := make(map[string]struct{})
+	for _, f := range files {
+		for _, e := range f.entries {
+			switch e.marker {
+			case markerRevoked:
+				if bytes.Equal(e.key, key) || cert != nil && bytes.Equal(e.key, cert.SignatureKey) {
+					return nil
+				}
+			case markerCA:
+				if cert == nil || !bytes.Equal(e.key, cert.SignatureKey) {
+					continue
+				}
+				for _, p := range cert.Principals {
+					if e.matches(p, ports) && cert.ValidFor(agentproto.HostCert, p, time.Now()) {
+						found[p] = struct{}{}
+					}
+				}
+			default:
+				if !bytes.Equal(e.key, key) {
+					continue
+				}
+				for _, p := range e.patterns {
+					if !strings.ContainsAny(p, "*?") {
+						found[p] = struct{}{}
+					}
+				}
+				for _, name := range candidates {
+					for _, form := range hostForms(name, ports) {
+						if e.matchesHashed(form) {
+							found[form] = struct{}{}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// load returns parsed files, re-reading the ones which changed.
+func (db *DB) load() []*file {
+	var res []*file
+	for _, name := range db.paths {
+		fi, err := os.Stat(name)
+		if err != nil {
+			delete(db.files, name)
+			continue
+		}
+		f, ok := db.files[name]
+		if !ok || !f.mtime.Equal(fi.ModTime()) {
+			if f, err = parseFile(name); err != nil {
+				logging.Warnf("Unable to read %s: %s", name, err)
+				delete(db.files, name)
+				continue
+			}
+			f.mtime = fi.ModTime()
+			db.files[name] = f
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+func parseFile(name string) (*file, error) {
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &file{}
+	names, ports := make(map[string]struct{}), make(map[string]struct{})
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		e, ok := parseLine(sc.Text())
+		if !ok {
+			continue
+		}
+		for _, p := range e.patterns {
+			if strings.ContainsAny(p, "*?") {
+				continue
+			}
+			host, port := splitHostPort(p)
+			names[host] = struct{}{}
+			if port != "" && port != "22" {
+				ports[port] = struct{}{}
+			}
+		}
+		f.entries = append(f.entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	hosts, hostPorts := configHosts(filepath.Join(filepath.Dir(name), "config"))
+	for _, name := range hosts {
+		names[name] = struct{}{}
+	}
+	for _, port := range hostPorts {
+		if port != "22" {
+			ports[port] = struct{}{}
+		}
+	}
+	for name := range names {
+		f.names = append(f.names, name)
+	}
+	for port := range ports {
+		f.ports = append(f.ports, port)
+	}
+	return f, nil
+}
+
+func parseLine(line string) (entry, bool) {
+
+	var e entry
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return e, false
+	}
+	if strings.HasPrefix(fields[0], "@") {
+		e.marker, fields = fields[0], fields[1:]
+		if e.marker != markerCA && e.marker != markerRevoked {
+			return e, false
+		}
+	}
+	if len(fields) < 3 {
+		return e, false
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return e, false
+	}
+	if t, err := agentproto.KeyType(key); err != nil || t != fields[1] {
+		return e, false
+	}
+	e.key = key
+
+	for _, p := range strings.Split(fields[0], ",") {
+		switch {
+		case strings.HasPrefix(p, hashPrefix):
+			parts := strings.Split(p[len(hashPrefix):], "|")
+			if len(parts) != 2 {
+				continue
+			}
+			salt, err1 := base64.StdEncoding.DecodeString(parts[0])
+			hash, err2 := base64.StdEncoding.DecodeString(parts[1])
+			if err1 == nil && err2 == nil {
+				e.hashed = append(e.hashed, hashed{salt: salt, hash: hash})
+			}
+		case strings.HasPrefix(p, "!"):
+			// negated patterns never name a host
+		case len(p) > 0:
+			e.patterns = append(e.patterns, p)
+		}
+	}
+	return e, true
+}
+
+// matches reports if name, alone or on one of ports, matches one of entry patterns.
+func (e *entry) matches(name string, ports []string) bool {
+	for _, form := range hostForms(name, ports) {
+		for _, p := range e.patterns {
+			if ok, _ := path.Match(p, form); ok || p == form {
+				return true
+			}
+		}
+		if e.matchesHashed(form) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostForms returns ways known_hosts could name host: by itself for standard port and [host]:port for other ports.
+func hostForms(host string, ports []string) []string {
+	forms := []string{host}
+	for _, port := range ports {
+		forms = append(forms, "["+host+"]:"+port)
+	}
+	return forms
+}
+
+// splitHostPort splits [host]:port pattern, port is empty for plain host name.
+func splitHostPort(p string) (string, string) {
+	if strings.HasPrefix(p, "[") {
+		if i := strings.LastIndex(p, "]:"); i > 0 {
+			return p[1:i], p[i+2:]
+		}
+	}
+	return p, ""
+}
+
+func (e *entry) matchesHashed(name string) bool {
+	for _, h := range e.hashed {
+		mac := hmac.New(sha1.New, h.salt)
+		mac.Write([]byte(name))
+		if hmac.Equal(mac.Sum(nil), h.hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// configHosts returns host names from Host and HostName directives of ssh config file and ports from Port directives.
+func configHosts(name string) ([]string, []string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil
+	}
+	var names, ports []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "host", "hostname":
+			for _, name := range fields[1:] {
+				if !strings.ContainsAny(name, "*?!%") {
+					names = append(names, name)
+				}
+			}
+		case "port":
+			ports = append(ports, fields[1])
+		}
+	}
+	return names, ports
+}
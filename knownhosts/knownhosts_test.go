@@ -0,0 +1,138 @@
+package knownhosts
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+// newKey returns wire encoded ed25519 public key and its private part.
+func newKey(t *testing.T) ([]byte, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agentproto.AppendBytes(agentproto.AppendString(nil, "ssh-ed25519"), pub), priv
+}
+
+// hostCert returns host certificate for key signed by ca.
+func hostCert(t *testing.T, key []byte, ca []byte, caPriv ed25519.PrivateKey, principals ...string) []byte {
+	t.Helper()
+	var names []byte
+	for _, p := range principals {
+		names = agentproto.AppendString(names, p)
+	}
+	r := agentproto.NewReader(key)
+	r.Text()
+	b := agentproto.AppendString(nil, "ssh-ed25519-cert-v01@openssh.com")
+	b = agentproto.AppendBytes(b, []byte("nonce"))
+	b = agentproto.AppendBytes(b, r.Bytes())
+	b = agentproto.AppendUint32(agentproto.AppendUint32(b, 0), 1) // serial
+	b = agentproto.AppendUint32(b, agentproto.HostCert)
+	b = agentproto.AppendString(b, "host")
+	b = agentproto.AppendBytes(b, names)
+	b = agentproto.AppendUint32(agentproto.AppendUint32(b, 0), 0)
+	b = agentproto.AppendUint32(agentproto.AppendUint32(b, 0xffffffff), 0xffffffff)
+	b = agentproto.AppendBytes(b, nil)
+	b = agentproto.AppendBytes(b, nil)
+	b = agentproto.AppendBytes(b, nil)
+	b = agentproto.AppendBytes(b, ca)
+	sig := agentproto.AppendBytes(agentproto.AppendString(nil, "ssh-ed25519"), ed25519.Sign(caPriv, b))
+	return agentproto.AppendBytes(b, sig)
+}
+
+// hash returns hashed known_hosts pattern for name.
+func hash(name string) string {
+	salt := make([]byte, sha1.Size)
+	rand.Read(salt)
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return hashPrefix + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func line(marker, hosts string, key []byte) string {
+	l := hosts + " ssh-ed25519 " + base64.StdEncoding.EncodeToString(key)
+	if marker != "" {
+		l = marker + " " + l
+	}
+	return l
+}
+
+func TestLookup(t *testing.T) {
+	key, _ := newKey(t)
+	other, _ := newKey(t)
+	ca, caPriv := newKey(t)
+	cert := hostCert(t, key, ca, caPriv, "ca.example.com")
+
+	tests := []struct {
+		name   string
+		lines  []string
+		config string
+		key    []byte
+		want   []string
+	}{
+		{name: "plain", lines: []string{line("", "example.com,!bad.example.com,*.wild", key)}, key: key, want: []string{"example.com"}},
+		{name: "other key", lines: []string{line("", "example.com", other)}, key: key, want: []string{}},
+		{name: "hashed named by plain entry", lines: []string{line("", hash("example.com"), key), line("", "example.com", other)}, key: key, want: []string{"example.com"}},
+		{name: "hashed named by config", lines: []string{line("", hash("example.com"), key)}, config: "Host alias\n  HostName example.com\n", key: key, want: []string{"example.com"}},
+		{name: "hashed unknown", lines: []string{line("", hash("example.com"), key)}, key: key, want: []string{}},
+		{name: "plain port", lines: []string{line("", "[example.com]:2222", key)}, key: key, want: []string{"[example.com]:2222"}},
+		{name: "hashed port from plain entry", lines: []string{line("", hash("[example.com]:2222"), key), line("", "[other.com]:2222,example.com", other)}, key: key, want: []string{"[example.com]:2222"}},
+		{name: "hashed port from config", lines: []string{line("", hash("[example.com]:2222"), key)}, config: "Host example.com\n  Port 2222\n", key: key, want: []string{"[example.com]:2222"}},
+		{name: "hashed standard port", lines: []string{line("", hash("example.com"), key)}, config: "Host example.com\n  Port 22\n", key: key, want: []string{"example.com"}},
+		{name: "cert authority", lines: []string{line(markerCA, "*.example.com", ca)}, key: cert, want: []string{"ca.example.com"}},
+		{name: "hashed cert authority", lines: []string{line(markerCA, hash("ca.example.com"), ca)}, key: cert, want: []string{"ca.example.com"}},
+		{name: "cert authority for other hosts", lines: []string{line(markerCA, "*.example.org", ca)}, key: cert, want: []string{}},
+		{name: "revoked", lines: []string{line("", "example.com", key), line(markerRevoked, "*", key)}, key: key},
+		{name: "revoked authority", lines: []string{line(markerCA, "*.example.com", ca), line(markerRevoked, "*", ca)}, key: cert},
+		{name: "comment and garbage", lines: []string{"# example.com ssh-ed25519 AAAA", "@unknown example.com ssh-ed25519 AAAA", line("", "example.com", key)}, key: key, want: []string{"example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "known_hosts")
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.config != "" {
+				if err := os.WriteFile(filepath.Join(dir, "config"), []byte(tt.config), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := New(path, filepath.Join(dir, "missing")).Lookup(tt.key)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	key, _ := newKey(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	db := New(path)
+	if got := db.Lookup(key); len(got) != 0 {
+		t.Errorf("Lookup without file = %v", got)
+	}
+	os.WriteFile(path, []byte(line("", "example.com", key)+"\n"), 0600)
+	if got := db.Lookup(key); !reflect.DeepEqual(got, []string{"example.com"}) {
+		t.Errorf("Lookup after write = %v", got)
+	}
+	os.WriteFile(path, []byte(line("", "example.org", key)+"\n"), 0600)
+	// modification time has to differ
+	os.Chtimes(path, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if got := db.Lookup(key); !reflect.DeepEqual(got, []string{"example.org"}) {
+		t.Errorf("Lookup after change = %v", got)
+	}
+}
@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"wsl-ssh-agent/rotate"
+)
+
+// File is Sink writing entries as text lines into file which is rotated when it grows over size limit.
+type File struct {
+	*rotate.File
+}
+
+// OpenFile opens (or creates) log file. When file grows over maxSize bytes it is renamed to path.1, previous path.1
+// becomes path.2 and so on, only keep old files are retained.
+func OpenFile(path string, maxSize int64, keep int) (*File, error) {
+	rf, err := rotate.Open("log", path, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: rf}, nil
+}
+
+// Write appends entry to the file. Errors are ignored, logging them would come back here.
+func (lf *File) Write(e *Entry) {
+	_, _ = lf.File.Write([]byte(e.String() + "\n"))
+}
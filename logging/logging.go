@@ -0,0 +1,195 @@
+// Package logging adds levels to standard log package and sends its output to several sinks: debugger, rotating file
+// and in-memory ring which could be followed.
+//
+// Messages written with log.Print* have Info level, Debugf, Warnf and Errorf mark them otherwise. Until Install makes
+// standard log write to sinks, leveled messages are passed to it as they are. Default level is Info.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is message severity.
+type Level int32
+
+// Levels in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= LevelDebug && l <= LevelError {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *Level) UnmarshalText(text []byte) error {
+	v, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// ParseLevel converts level name to Level.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, should be one of %s", name, strings.Join(levelNames[:], ", "))
+}
+
+// Entry is single log message.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   Level     `json:"level"`
+	Message string    `json:"msg"`
+}
+
+// String formats entry as text line without line end.
+func (e *Entry) String() string {
+	return fmt.Sprintf("%s %-5s %s", e.Time.Format("2006-01-02 15:04:05.000"), strings.ToUpper(e.Level.String()), e.Message)
+}
+
+// Sink receives log entries. Implementations must be safe for concurrent use and must not log themselves.
+type Sink interface {
+	Write(e *Entry)
+}
+
+// mark starts message written by leveled functions, it is followed by level digit.
+const mark = '\x1f'
+
+var (
+	level  atomic.Int32
+	output = &writer{}
+)
+
+func init() {
+	SetLevel(LevelInfo)
+}
+
+// SetLevel changes minimal level of messages which are kept.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// GetLevel returns current minimal level.
+func GetLevel() Level {
+	return Level(level.Load())
+}
+
+// Enabled reports if messages of level l are kept.
+func Enabled(l Level) bool {
+	return l >= GetLevel()
+}
+
+// Debugf logs message with Debug level.
+func Debugf(format string, args ...any) {
+	logf(LevelDebug, format, args...)
+}
+
+// Infof logs message with Info level, the level of standard log messages.
+func Infof(format string, args ...any) {
+	logf(LevelInfo, format, args...)
+}
+
+// Warnf logs message with Warn level.
+func Warnf(format string, args ...any) {
+	logf(LevelWarn, format, args...)
+}
+
+// Errorf logs message with Error level.
+func Errorf(format string, args ...any) {
+	logf(LevelError, format, args...)
+}
+
+func logf(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if log.Writer() == io.Writer(output) {
+		// only our writer knows the mark
+		msg = string([]byte{mark, byte('0' + l)}) + msg
+	}
+	_ = log.Output(3, msg)
+}
+
+// Install makes standard log send messages to sinks.
+func Install(sinks ...Sink) {
+	output.mu.Lock()
+	output.sinks = sinks
+	output.mu.Unlock()
+	log.SetPrefix("")
+	log.SetFlags(0)
+	log.SetOutput(output)
+}
+
+// writer is standard log output, it turns lines into entries.
+type writer struct {
+	mu    sync.RWMutex
+	sinks []Sink
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+
+	e := &Entry{Time: time.Now(), Level: LevelInfo}
+	msg := bytes.TrimRight(p, "\n")
+	if len(msg) > 1 && msg[0] == mark {
+		e.Level = Level(msg[1] - '0')
+		msg = msg[2:]
+	}
+	if !Enabled(e.Level) {
+		return len(p), nil
+	}
+	e.Message = string(msg)
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, s := range w.sinks {
+		s.Write(e)
+	}
+	return len(p), nil
+}
+
+// TextSink is Sink writing entries as text lines to io.Writer, like debugger output.
+type TextSink struct {
+	prefix string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextSink creates TextSink, prefix starts every line.
+func NewTextSink(prefix string, w io.Writer) *TextSink {
+	return &TextSink{prefix: prefix, w: w}
+}
+
+// Write writes entry as text line.
+func (t *TextSink) Write(e *Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = io.WriteString(t.w, t.prefix+e.String()+"\n")
+}
@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capture installs ring as the only sink for the test.
+func capture(t *testing.T) *Ring {
+	t.Helper()
+	r := NewRing(16)
+	Install(r)
+	t.Cleanup(func() {
+		SetLevel(LevelInfo)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return r
+}
+
+func TestLevels(t *testing.T) {
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{level: LevelDebug, want: []string{"debug d", "info i", "info f", "warn w", "error e"}},
+		{level: LevelInfo, want: []string{"info i", "info f", "warn w", "error e"}},
+		{level: LevelWarn, want: []string{"warn w", "error e"}},
+		{level: LevelError, want: []string{"error e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			r := capture(t)
+			SetLevel(tt.level)
+			Debugf("%s", "d")
+			log.Print("i")
+			Infof("f")
+			Warnf("w")
+			Errorf("e")
+
+			var got []string
+			for _, e := range r.Entries() {
+				got = append(got, e.Level.String()+" "+e.Message)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("entries %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		l, err := ParseLevel(name)
+		if err != nil || !strings.EqualFold(l.String(), name) {
+			t.Errorf("ParseLevel(%q) = %s, %v", name, l, err)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel(loud) succeeded, want error")
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+	write := func(msgs ...string) {
+		for _, m := range msgs {
+			r.Write(&Entry{Message: m})
+		}
+	}
+	messages := func(entries []*Entry) string {
+		var list []string
+		for _, e := range entries {
+			list = append(list, e.Message)
+		}
+		return strings.Join(list, ",")
+	}
+
+	write("a", "b")
+	if got := messages(r.Entries()); got != "a,b" {
+		t.Errorf("entries %q, want a,b", got)
+	}
+	write("c", "d", "e")
+	if got := messages(r.Entries()); got != "c,d,e" {
+		t.Errorf("entries after wrap %q, want c,d,e", got)
+	}
+
+	old, ch := r.Follow()
+	if got := messages(old); got != "c,d,e" {
+		t.Errorf("followed entries %q, want c,d,e", got)
+	}
+	write("f")
+	if e := <-ch; e.Message != "f" {
+		t.Errorf("follower got %q, want f", e.Message)
+	}
+	r.Unfollow(ch)
+	if _, ok := <-ch; ok {
+		t.Error("channel is open after Unfollow")
+	}
+}
+
+func TestRingSlowFollower(t *testing.T) {
+	r := NewRing(1)
+	_, ch := r.Follow()
+	for i := 0; i <= followerQueue; i++ {
+		r.Write(&Entry{})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != followerQueue {
+		t.Errorf("slow follower got %d entries before channel was closed, want %d", n, followerQueue)
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	var b bytes.Buffer
+	s := NewTextSink("[test] ", &b)
+	s.Write(&Entry{Time: time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), Level: LevelWarn, Message: "backend down"})
+	if got, want := b.String(), "[test] 2024-05-01 10:20:30.000 WARN  backend down\n"; got != want {
+		t.Errorf("text %q, want %q", got, want)
+	}
+}
+
+func TestNotInstalled(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+
+	if GetLevel() != LevelInfo {
+		t.Errorf("default level %s, want info", GetLevel())
+	}
+	Debugf("d")
+	Warnf("w %d", 1)
+	if got := buf.String(); got != "w 1\n" {
+		t.Errorf("standard log got %q, want plain message", got)
+	}
+}
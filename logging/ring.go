@@ -0,0 +1,72 @@
+package logging
+
+import "sync"
+
+// followerQueue is how many entries could wait for slow follower before it is dropped.
+const followerQueue = 1024
+
+// Ring keeps last entries in memory.
+type Ring struct {
+	mu        sync.Mutex
+	entries   []*Entry
+	next      int
+	full      bool
+	followers map[chan *Entry]struct{}
+}
+
+// NewRing creates ring holding up to size entries.
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]*Entry, size), followers: make(map[chan *Entry]struct{})}
+}
+
+// Write stores entry, oldest one is dropped when ring is full.
+func (r *Ring) Write(e *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	r.full = r.full || r.next == 0
+	for ch := range r.followers {
+		select {
+		case ch <- e:
+		default:
+			// follower does not keep up
+			delete(r.followers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Entries returns stored entries, oldest first.
+func (r *Ring) Entries() []*Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entriesLocked()
+}
+
+func (r *Ring) entriesLocked() []*Entry {
+	if !r.full {
+		return append([]*Entry(nil), r.entries[:r.next]...)
+	}
+	return append(append([]*Entry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// Follow returns stored entries and channel which receives every entry written afterwards. Channel is closed by
+// Unfollow or when follower does not keep up.
+func (r *Ring) Follow() ([]*Entry, chan *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch := make(chan *Entry, followerQueue)
+	r.followers[ch] = struct{}{}
+	return r.entriesLocked(), ch
+}
+
+// Unfollow stops sending entries to ch.
+func (r *Ring) Unfollow(ch chan *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.followers[ch]; ok {
+		delete(r.followers, ch)
+		close(ch)
+	}
+}
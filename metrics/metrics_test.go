@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric func(r *Registry)
+		want   string
+	}{
+		{
+			name:   "counter without labels",
+			metric: func(r *Registry) { r.Counter("denials_total", "Denied requests.") },
+			want:   "# HELP denials_total Denied requests.\n# TYPE denials_total counter\ndenials_total 0\n",
+		},
+		{
+			name: "counter with labels",
+			metric: func(r *Registry) {
+				c := r.Counter("requests_total", "Requests by type.", "type")
+				c.Inc("sign")
+				c.Add(2.5, "list")
+				c.Inc("sign")
+			},
+			want: "# HELP requests_total Requests by type.\n# TYPE requests_total counter\n" +
+				"requests_total{type=\"list\"} 2.5\nrequests_total{type=\"sign\"} 2\n",
+		},
+		{
+			name: "escaping",
+			metric: func(r *Registry) {
+				r.Counter("errors_total", "Errors\nby \\ backend.", "backend").Inc("a\"b\\c\nd")
+			},
+			want: "# HELP errors_total Errors\\nby \\\\ backend.\n# TYPE errors_total counter\n" +
+				"errors_total{backend=\"a\\\"b\\\\c\\nd\"} 1\n",
+		},
+		{
+			name: "gauge",
+			metric: func(r *Registry) {
+				g := r.Gauge("connections", "Open connections.")
+				g.Add(3)
+				g.Add(-1)
+			},
+			want: "# HELP connections Open connections.\n# TYPE connections gauge\nconnections 2\n",
+		},
+		{
+			name: "histogram",
+			metric: func(r *Registry) {
+				h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "key")
+				h.Observe(0.05, "k")
+				h.Observe(0.1, "k")
+				h.Observe(0.5, "k")
+				h.Observe(3, "k")
+			},
+			want: "# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" +
+				"latency_seconds_bucket{key=\"k\",le=\"0.1\"} 2\n" +
+				"latency_seconds_bucket{key=\"k\",le=\"1\"} 3\n" +
+				"latency_seconds_bucket{key=\"k\",le=\"+Inf\"} 4\n" +
+				"latency_seconds_sum{key=\"k\"} 3.65\n" +
+				"latency_seconds_count{key=\"k\"} 4\n",
+		},
+		{
+			name: "order of registration",
+			metric: func(r *Registry) {
+				r.Gauge("b", "B.")
+				r.Gauge("a", "A.")
+			},
+			want: "# HELP b B.\n# TYPE b gauge\nb 0\n# HELP a A.\n# TYPE a gauge\na 0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.metric(r)
+			if got := r.Text(); got != tt.want {
+				t.Errorf("exposition:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("up", "Up.").Set(1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	if got, want := w.Body.String(), r.Text(); got != want {
+		t.Errorf("body %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// Access is level of agent access server grants to every client, session policy changes it.
+type Access int32
+
+// Access levels.
+const (
+	AccessFull Access = iota
+	AccessList        // identities could be listed, everything else is refused
+	AccessNone
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessFull:
+		return "full"
+	case AccessList:
+		return "list"
+	case AccessNone:
+		return "none"
+	}
+	return fmt.Sprintf("UNKNOWN (%d)", int32(a))
+}
+
+// accessByLock marks access level which is caused by locked user session.
+const accessByLock = 1 << 8
+
+// SetAccess changes access level of all clients, byLock tells that it is locked user session which limits access.
+func (s *Server) SetAccess(a Access, byLock bool) {
+	v := int32(a)
+	if byLock && a != AccessFull {
+		v |= accessByLock
+	}
+	if old := Access(s.access.Swap(v) &^ accessByLock); old != a {
+		logging.Infof("Agent access changed from %s to %s", old, a)
+	}
+}
+
+// Access returns current access level.
+func (s *Server) Access() Access {
+	return Access(s.access.Load() &^ accessByLock)
+}
+
+// sessionLocked reports if access is limited because user session is locked.
+func (s *Server) sessionLocked() bool {
+	return s.access.Load()&accessByLock != 0
+}
+
+// denied returns why request could not be served in current lock state and access level and metrics failure reason,
+// both are empty when it could. Status extension is served like listing, lock extension could lift proxy lock.
+func (s *Server) denied(req []byte) (string, string) {
+	ext := ownExtension(req)
+	if s.proxyLock.Load() && ext != ExtLock {
+		return fmt.Sprintf("access is locked by %s", ExtLock), failProxyLocked
+	}
+	switch a := s.Access(); a {
+	case AccessFull:
+		return "", ""
+	case AccessList:
+		if agentproto.MessageType(req[4]) == agentproto.RequestIdentitiesMsg || ext == ExtStatus {
+			return "", ""
+		}
+		fallthrough
+	default:
+		if s.sessionLocked() {
+			return "session is locked", failSessionLocked
+		}
+		return fmt.Sprintf("access is limited to %s by session policy", a), failAccess
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// DefaultApprovalTimeout is how long request waits for approver answer before it is denied.
+const DefaultApprovalTimeout = 30 * time.Second
+
+// Approval describes agent operation waiting for confirmation.
+type Approval struct {
+	// Listener is name of the listener request came through.
+	Listener string
+	// Key is identity to be used.
+	Key agentproto.Identity
+	// Data describes what is going to be signed, if known.
+	Data *agentproto.SignedData
+	// Host is name of the server user authenticates to, if known.
+	Host string
+}
+
+// Prompt returns human readable question for Approval.
+func (a *Approval) Prompt() string {
+	p := fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.\nRequested through %s.",
+		a.Key.Comment, agentproto.Fingerprint(a.Key.KeyBlob), a.Listener)
+	if a.Data != nil {
+		p += fmt.Sprintf("\nPurpose: %s.", a.Data)
+	}
+	if len(a.Host) > 0 {
+		p += fmt.Sprintf("\nHost: %s.", a.Host)
+	}
+	return p
+}
+
+// Approver confirms agent operations. Approve should return as soon as ctx is done, request is denied at that point
+// anyway.
+type Approver interface {
+	Approve(ctx context.Context, a *Approval) (bool, error)
+}
+
+// ApproverFunc is an adapter to allow use of ordinary functions as Approver.
+type ApproverFunc func(ctx context.Context, a *Approval) (bool, error)
+
+// Approve calls f(ctx, a).
+func (f ApproverFunc) Approve(ctx context.Context, a *Approval) (bool, error) {
+	return f(ctx, a)
+}
+
+// AutoApprover answers every request with its own value without asking anybody.
+type AutoApprover bool
+
+// Approve returns bool(v).
+func (v AutoApprover) Approve(context.Context, *Approval) (bool, error) {
+	return bool(v), nil
+}
+
+type commandApprover struct {
+	path string
+}
+
+// NewCommandApprover returns Approver which runs SSH_ASKPASS style program the way ssh-agent does for confirmation:
+// prompt is the only argument, SSH_ASKPASS_PROMPT is set to "confirm" and zero exit status means approval.
+func NewCommandApprover(path string) Approver {
+	return &commandApprover{path: path}
+}
+
+func (c *commandApprover) Approve(ctx context.Context, a *Approval) (bool, error) {
+	cmd := exec.CommandContext(ctx, c.path, a.Prompt())
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ctx.Err() == nil {
+		return false, nil
+	}
+	return false, fmt.Errorf("unable to run %s: %w", c.path, err)
+}
+
+// WithApprover sets approver asking for confirmations. Requests without answer are denied after timeout. Without
+// approver every confirmation is denied.
+func WithApprover(approver Approver, timeout time.Duration) Option {
+	return func(s *Server) {
+		if timeout <= 0 {
+			timeout = DefaultApprovalTimeout
+		}
+		s.set.approver, s.set.approvalTimeout = approver, timeout
+	}
+}
+
+// WithConfirm makes server hold every sign request with keys passing filter until approver allows it.
+func WithConfirm(keys *KeyFilter) Option {
+	return func(s *Server) {
+		s.set.confirm = keys
+	}
+}
+
+// ask waits for approver answer no longer than timeout.
+func ask(approver Approver, timeout time.Duration, handle string, a *Approval) bool {
+
+	if approver == nil {
+		logging.Warnf("[%s] No way to ask for confirmation", handle)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type answer struct {
+		ok  bool
+		err error
+	}
+	ch := make(chan answer, 1)
+	go func() {
+		ok, err := approver.Approve(ctx, a)
+		ch <- answer{ok, err}
+	}()
+
+	logging.Infof("[%s] Waiting for confirmation to use key %s", handle, agentproto.Fingerprint(a.Key.KeyBlob))
+	select {
+	case ans := <-ch:
+		if ans.err != nil {
+			logging.Warnf("[%s] Confirmation failed: %s", handle, ans.err)
+			return false
+		}
+		logging.Infof("[%s] Confirmation answer: %t", handle, ans.ok)
+		return ans.ok
+	case <-ctx.Done():
+		logging.Infof("[%s] Confirmation timed out", handle)
+		return false
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/audit"
+)
+
+// WithAudit makes server send record of every request to sink.
+func WithAudit(sink audit.Sink) Option {
+	return func(s *Server) {
+		s.audit = sink
+	}
+}
+
+// record describes request, its outcome and sends it to audit sink.
+func (s *Server) record(c *client, req, res []byte, err error, latency time.Duration) {
+
+	r := &audit.Record{
+		Time:     time.Now().Add(-latency),
+		Listener: c.ln.Name(),
+		Latency:  float64(latency.Microseconds()) / 1000,
+	}
+
+	if m, perr := agentproto.Parse(req[4:]); perr != nil {
+		r.Type = agentproto.MessageType(req[4]).String()
+	} else {
+		r.Type = m.Type().String()
+		switch m := m.(type) {
+		case *agentproto.SignRequest:
+			r.Key = agentproto.Fingerprint(m.KeyBlob)
+			d := c.signedData(m.Data)
+			r.Data = auditSigned(d)
+			r.Data.HostName = s.settings().hostName(d.HostKey)
+		case *agentproto.RemoveIdentity:
+			r.Key = agentproto.Fingerprint(m.KeyBlob)
+		case *agentproto.AddIdentity:
+			if blob, err := m.PublicKey(); err == nil {
+				r.Key = agentproto.Fingerprint(blob)
+			}
+			r.Comment = m.Comment
+		case *agentproto.Extension:
+			r.Type += " " + m.Name
+		}
+	}
+
+	switch {
+	case len(c.refused) > 0:
+		r.Result, r.Reason = "refused", c.refused
+	case err != nil:
+		r.Result, r.Reason = "error", err.Error()
+	case len(res) > 4:
+		r.Result = agentproto.MessageType(res[4]).String()
+	}
+	s.audit.Audit(r)
+}
+
+func auditSigned(d *agentproto.SignedData) *audit.Signed {
+	a := &audit.Signed{
+		Kind:      d.Kind,
+		Size:      d.Size,
+		User:      d.User,
+		Service:   d.Service,
+		Namespace: d.Namespace,
+		Hash:      d.HashAlgorithm,
+	}
+	if d.SessionID != nil {
+		a.Session = hex.EncodeToString(d.SessionID)
+	}
+	if d.HostKey != nil {
+		a.Host = agentproto.Fingerprint(d.HostKey)
+	}
+	return a
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/audit"
+)
+
+// auditLog keeps records in memory.
+type auditLog struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+func (l *auditLog) Audit(r *audit.Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r)
+}
+
+func (l *auditLog) last() *audit.Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return nil
+	}
+	return l.records[len(l.records)-1]
+}
+
+func TestAuditRefusals(t *testing.T) {
+	log := &auditLog{}
+	s := NewServer(memoryBackend(), WithAudit(log))
+	path := serve(t, s, ListenerOptions{})
+	readOnly := serve(t, s, ListenerOptions{ReadOnly: true})
+
+	key := newKey(t, "me@work")
+	call(t, path, key)
+
+	tests := []struct {
+		name   string
+		path   string
+		req    agentproto.Message
+		setup  func()
+		result string
+		reason string
+	}{
+		{name: "list", path: path, req: &agentproto.RequestIdentities{}, result: agentproto.IdentitiesAnswerMsg.String()},
+		{name: "read-only add", path: readOnly, req: newKey(t, "other"), result: "refused", reason: "through read-only listener"},
+		{name: "sign while locked", path: path, req: &agentproto.SignRequest{KeyBlob: blob(t, key), Data: []byte("data")},
+			setup: func() { s.SetLocked(true) }, result: "refused", reason: "access is locked"},
+		{name: "session access", path: path, req: &agentproto.RequestIdentities{},
+			setup: func() { s.SetAccess(AccessNone, true) }, result: "refused", reason: "session is locked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setup != nil {
+				tt.setup()
+				defer s.SetLocked(false)
+				defer s.SetAccess(AccessFull, false)
+			}
+			call(t, tt.path, tt.req)
+			r := log.last()
+			if r == nil || r.Type != tt.req.Type().String() || r.Result != tt.result || !strings.Contains(r.Reason, tt.reason) {
+				t.Fatalf("record %+v, want %s with result %s and reason %q", r, tt.req.Type(), tt.result, tt.reason)
+			}
+			if tt.reason == "" && r.Reason != "" {
+				t.Errorf("record reason %q", r.Reason)
+			}
+		})
+	}
+	if r := log.records[0]; r.Comment != "me@work" || r.Key != agentproto.Fingerprint(blob(t, key)) {
+		t.Errorf("add record %+v, want key and comment", r)
+	}
+}
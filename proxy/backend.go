@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// Backend is an upstream ssh-agent requests are relayed to.
+type Backend interface {
+	// Name returns human readable backend name to be used in logs and diagnostics.
+	Name() string
+	// Dial opens new connection to the agent.
+	Dial() (net.Conn, error)
+	// Query sends single framed request to the agent and returns its framed reply.
+	Query(req []byte) ([]byte, error)
+}
+
+// DialFunc opens connection to the agent.
+type DialFunc func() (net.Conn, error)
+
+type dialBackend struct {
+	name string
+	dial DialFunc
+
+	dialFailed func(name string) // set by watchDials
+}
+
+// dialWatcher is implemented by backends which report failed connection attempts.
+type dialWatcher interface {
+	watchDials(failed func(name string))
+}
+
+// watchDials makes backend (and backends it aggregates) call failed for every connection attempt which fails.
+func watchDials(b Backend, failed func(name string)) {
+	if w, ok := b.(dialWatcher); ok {
+		w.watchDials(failed)
+	}
+}
+
+// NewBackend returns Backend which opens new connection using dial for every query.
+func NewBackend(name string, dial DialFunc) Backend {
+	return &dialBackend{name: name, dial: dial}
+}
+
+func (b *dialBackend) Name() string {
+	return b.name
+}
+
+func (b *dialBackend) Dial() (net.Conn, error) {
+	conn, err := b.dial()
+	if err != nil {
+		if b.dialFailed != nil {
+			b.dialFailed(b.name)
+		}
+		return nil, fmt.Errorf("cannot connect to %s: %w", b.name, err)
+	}
+	return conn, nil
+}
+
+func (b *dialBackend) watchDials(failed func(name string)) {
+	b.dialFailed = failed
+}
+
+func (b *dialBackend) Query(req []byte) ([]byte, error) {
+	return dialQuery(b, req)
+}
+
+// dialQuery performs single request over fresh backend connection.
+func dialQuery(b Backend, req []byte) ([]byte, error) {
+
+	conn, err := b.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	logging.Debugf("Connected to %s: %d", b.Name(), len(req))
+
+	return roundTrip(conn, b.Name(), req)
+}
+
+// NewPipeBackend returns Backend talking to agent listening on Windows named pipe, like ssh-agent.exe.
+func NewPipeBackend(pipeName string) Backend {
+	return NewBackend(pipeName, func() (net.Conn, error) {
+		return dialPipe(pipeName)
+	})
+}
+
+// Handler processes agent requests in-process. Nil reply drops connection, like agent failing to answer would.
+type Handler interface {
+	Handle(req agentproto.Message) agentproto.Message
+}
+
+// Clearer is implemented by backends which are able to drop all keys they hold, like in-process memory agent.
+type Clearer interface {
+	Clear()
+}
+
+type localBackend struct {
+	Backend
+	h Handler
+}
+
+// NewLocalBackend returns Backend served by in-process Handler. Every connection is a synchronous in-memory pipe.
+// If Handler implements Clearer so does returned Backend.
+func NewLocalBackend(name string, h Handler) Backend {
+	return &localBackend{
+		Backend: NewBackend(name, func() (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveHandler(server, h)
+			return client, nil
+		}),
+		h: h,
+	}
+}
+
+func (b *localBackend) Clear() {
+	if c, ok := b.h.(Clearer); ok {
+		c.Clear()
+	}
+}
+
+type fallbackBackend struct {
+	primary, fallback Backend
+}
+
+// NewFallbackBackend returns Backend which connects to fallback when primary cannot be reached. Decision is made on
+// every Dial, so persistent client connections stay with whatever agent they started with until reconnect.
+func NewFallbackBackend(primary, fallback Backend) Backend {
+	return &fallbackBackend{primary: primary, fallback: fallback}
+}
+
+func (b *fallbackBackend) Name() string {
+	return fmt.Sprintf("%s (fallback %s)", b.primary.Name(), b.fallback.Name())
+}
+
+func (b *fallbackBackend) Dial() (net.Conn, error) {
+	conn, err := b.primary.Dial()
+	if err == nil {
+		return reached(b.primary, conn), nil
+	}
+	logging.Warnf("Using %s: %s", b.fallback.Name(), err)
+	if conn, err = b.fallback.Dial(); err != nil {
+		return nil, err
+	}
+	return reached(b.fallback, conn), nil
+}
+
+// agentConn is connection of backend which may reach one of several agents, it tells which one it is.
+type agentConn struct {
+	net.Conn
+	agent Backend
+}
+
+// reached marks connection with backend it leads to unless nested backend did it already.
+func reached(b Backend, conn net.Conn) net.Conn {
+	if _, ok := conn.(*agentConn); ok {
+		return conn
+	}
+	return &agentConn{Conn: conn, agent: b}
+}
+
+func (b *fallbackBackend) Query(req []byte) ([]byte, error) {
+	return dialQuery(b, req)
+}
+
+func (b *fallbackBackend) watchDials(failed func(name string)) {
+	watchDials(b.primary, failed)
+	watchDials(b.fallback, failed)
+}
+
+func (b *fallbackBackend) Clear() {
+	for _, be := range []Backend{b.primary, b.fallback} {
+		if c, ok := be.(Clearer); ok {
+			c.Clear()
+		}
+	}
+}
+
+func serveHandler(conn net.Conn, h Handler) {
+
+	defer conn.Close()
+	for {
+		req, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		var res agentproto.Message
+		if m, err := agentproto.Parse(req[4:]); err != nil {
+			logging.Warnf("Unable to parse request: %s", err)
+			res = &agentproto.Failure{}
+		} else {
+			res = h.Handle(m)
+		}
+		if res == nil {
+			return
+		}
+		if _, err := conn.Write(frame(agentproto.Marshal(res))); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"sync"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// Support of optional protocol features by the backend.
+type support int
+
+const (
+	supportUnknown support = iota
+	supported
+	unsupported
+)
+
+// compat remembers what backend agents turned out to support, by agent name as backend may reach several of them.
+// Agents older than the client (like Windows ssh-agent.exe shipped with the system) reject extensions and signature
+// flags recent OpenSSH uses.
+type compat struct {
+	mu   sync.Mutex
+	bind map[string]support
+	rsa  map[rsaSupport]support
+}
+
+type rsaSupport struct {
+	agent string
+	flag  agentproto.SignFlags
+}
+
+// WithCompat makes server smooth over features backend agent lacks: session bindings it rejects are accepted by proxy
+// (which verifies them and enforces destination constraints itself) and advertised in reply to query, constraints
+// which are only hints are dropped when agent refuses them and RSA SHA-2 signature flags agent ignores are detected.
+func WithCompat() Option {
+	return func(s *Server) {
+		s.compat = &compat{bind: make(map[string]support), rsa: make(map[rsaSupport]support)}
+	}
+}
+
+func (cm *compat) bound(agent string) support {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.bind[agent]
+}
+
+func (cm *compat) setBound(agent string, v support, what string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.bind[agent] != v && v == unsupported {
+		logging.Warnf("Agent %s does not support %s", agent, what)
+	}
+	cm.bind[agent] = v
+}
+
+// bindExtension relays session-bind extension, answering it when backend can not.
+func (cm *compat) bindExtension(c *client, m *agentproto.Extension) ([]byte, error) {
+
+	agent := c.up.holder(nil)
+	if cm.bound(agent) != unsupported {
+		res, err := c.up.queryMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		if !failed(res) {
+			cm.setBound(agent, supported, m.Name)
+			return frame(agentproto.Marshal(res)), nil
+		}
+		cm.setBound(agent, unsupported, m.Name)
+	}
+	// binding was verified and recorded by proxy already
+	return frame(agentproto.Marshal(&agentproto.Success{})), nil
+}
+
+// rsaAlgorithms are signature algorithms RSA sign flags ask for.
+var rsaAlgorithms = map[agentproto.SignFlags]string{
+	agentproto.SignRSASHA256: "rsa-sha2-256",
+	agentproto.SignRSASHA512: "rsa-sha2-512",
+}
+
+// sign relays sign request checking that agent honours RSA SHA-2 flags. Agent which does not know them signs with
+// SHA-1, server would reject such signature anyway, so failure is returned instead and client could try other keys.
+func (cm *compat) sign(c *client, m *agentproto.SignRequest, req []byte) ([]byte, error) {
+
+	flag := m.Flags & (agentproto.SignRSASHA256 | agentproto.SignRSASHA512)
+	want, ok := rsaAlgorithms[flag]
+	if !ok {
+		return c.up.query(req)
+	}
+	if t, err := agentproto.KeyType(m.KeyBlob); err != nil || t != "ssh-rsa" && t != "ssh-rsa-cert-v01@openssh.com" {
+		return c.up.query(req)
+	}
+
+	key := rsaSupport{agent: c.up.holder(m.KeyBlob), flag: flag}
+	cm.mu.Lock()
+	known := cm.rsa[key]
+	cm.mu.Unlock()
+	if known == unsupported {
+		return c.refuse("agent does not support %s signatures", want), nil
+	}
+
+	res, err := c.up.queryMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	sr, ok := res.(*agentproto.SignResponse)
+	if !ok {
+		return frame(agentproto.Marshal(res)), nil
+	}
+	r := agentproto.NewReader(sr.Signature)
+	got := r.Text()
+	if r.Err() != nil {
+		return frame(agentproto.Marshal(res)), nil
+	}
+	v := supported
+	if got != want {
+		v = unsupported
+	}
+	cm.mu.Lock()
+	if cm.rsa[key] != v && v == unsupported {
+		logging.Warnf("Agent %s does not support %s signatures, it signed with %s", key.agent, want, got)
+	}
+	cm.rsa[key] = v
+	cm.mu.Unlock()
+	if v == unsupported {
+		return c.refuse("agent signed with %s instead of %s", got, want), nil
+	}
+	return frame(agentproto.Marshal(res)), nil
+}
+
+// droppable are constraint extensions which could be omitted without weakening key protection.
+var droppable = map[string]bool{
+	agentproto.ExtAssociatedCerts: true,
+}
+
+// add retries key addition rejected by agent without constraints it could live without.
+func (cm *compat) add(c *client, m *agentproto.AddIdentity) (agentproto.Message, error) {
+
+	res, err := c.up.queryMessage(m)
+	if err != nil || !failed(res) || !m.Constrained {
+		return res, err
+	}
+	var rest []agentproto.Constraint
+	for _, con := range m.Constraints {
+		if ext, ok := con.(agentproto.ExtensionConstraint); ok && droppable[ext.Name] {
+			continue
+		}
+		rest = append(rest, con)
+	}
+	if len(rest) == len(m.Constraints) {
+		return res, nil
+	}
+	logging.Infof("[%s] Agent refused constrained key, adding it without %d hint constraint(s)", c.handle, len(m.Constraints)-len(rest))
+	add := *m
+	add.Constraints, add.Constrained = rest, len(rest) > 0
+	return c.up.queryMessage(&add)
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/keyring"
+)
+
+// sha1Agent is an old agent which ignores RSA SHA-2 sign flags.
+type sha1Agent struct {
+	*keyring.Keyring
+}
+
+func (a sha1Agent) Handle(req agentproto.Message) agentproto.Message {
+	if m, ok := req.(*agentproto.SignRequest); ok {
+		plain := *m
+		plain.Flags = 0
+		req = &plain
+	}
+	return a.Keyring.Handle(req)
+}
+
+func newRSAKey(t *testing.T, comment string) *agentproto.AddIdentity {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	for _, v := range []*big.Int{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]} {
+		b = agentproto.AppendMpint(b, v)
+	}
+	return &agentproto.AddIdentity{KeyType: "ssh-rsa", Key: b, Comment: comment}
+}
+
+func TestCompatRSAPerAgent(t *testing.T) {
+	old, recent := keyring.New(), keyring.New()
+	s := NewServer(NewMultiBackend(NewLocalBackend("old", sha1Agent{old}), NewLocalBackend("recent", recent)), WithCompat())
+	path := serve(t, s, ListenerOptions{})
+
+	oldKey, recentKey := newRSAKey(t, "old"), newRSAKey(t, "recent")
+	old.Handle(oldKey)
+	recent.Handle(recentKey)
+
+	sign := func(key *agentproto.AddIdentity) agentproto.Message {
+		return call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, key), Data: []byte("data"), Flags: agentproto.SignRSASHA256})
+	}
+	for i := 0; i < 2; i++ {
+		// second time refusal comes from what proxy remembers
+		if got := sign(oldKey); succeeded(got) {
+			t.Errorf("SHA-2 signature of old agent reply %s, want failure", got.Type())
+		}
+		res, ok := sign(recentKey).(*agentproto.SignResponse)
+		if !ok {
+			t.Fatal("SHA-2 signature of recent agent failed")
+		}
+		if format := agentproto.NewReader(res.Signature).Text(); format != "rsa-sha2-256" {
+			t.Errorf("signature format %q, want rsa-sha2-256", format)
+		}
+	}
+}
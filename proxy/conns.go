@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Connection describes open client connection.
+type Connection struct {
+	ID       uint64    `json:"id"`
+	Listener string    `json:"listener"`
+	Remote   string    `json:"remote,omitempty"`
+	Since    time.Time `json:"since"`
+	Requests uint64    `json:"requests"`
+
+	requests atomic.Uint64
+}
+
+// Connections returns open client connections ordered by ID.
+func (s *Server) Connections() []Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Connection, 0, len(s.conns))
+	for _, c := range s.conns {
+		list = append(list, Connection{ID: c.ID, Listener: c.Listener, Remote: c.Remote, Since: c.Since, Requests: c.requests.Load()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// DropConnection closes client connection with given ID, returns false when there is no such connection.
+func (s *Server) DropConnection(id uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, c := range s.conns {
+		if c.ID == id {
+			conn.Close()
+			delete(s.conns, conn)
+			return true
+		}
+	}
+	return false
+}
+
+// DropConnections closes all client connections and returns how many there were. Clients could connect again.
+func (s *Server) DropConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	n := len(s.conns)
+	clear(s.conns)
+	return n
+}
+
+func (s *Server) track(conn net.Conn, ln Listener) *Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastConn++
+	c := &Connection{ID: s.lastConn, Listener: ln.Name(), Since: time.Now()}
+	if addr := conn.RemoteAddr(); addr != nil && len(addr.String()) > 0 && addr.String() != "@" { // unnamed unix peer
+		c.Remote = addr.String()
+	}
+	s.conns[conn] = c
+	return c
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
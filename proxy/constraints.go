@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// keyConstraints are restrictions proxy enforces on behalf of the agent for a single key.
+type keyConstraints struct {
+	agent   Backend // key was added to, it is removed from there when lifetime expires
+	expire  *time.Timer
+	confirm bool
+	dests   []agentproto.DestinationConstraint
+}
+
+func (kc *keyConstraints) stop() {
+	if kc.expire != nil {
+		kc.expire.Stop()
+	}
+}
+
+// constraintKeeper holds emulated constraints of added keys by key blob.
+type constraintKeeper struct {
+	mu   sync.Mutex
+	keys map[string]*keyConstraints
+}
+
+// WithConstraints makes server enforce key constraints itself instead of passing them to the agent, which may not
+// support them: lifetime (ssh-add -t), confirmation (ssh-add -c, needs approver) and destination restrictions
+// (ssh-add -h). Unknown constraints are still passed through.
+func WithConstraints() Option {
+	return func(s *Server) {
+		s.constraints = &constraintKeeper{keys: make(map[string]*keyConstraints)}
+	}
+}
+
+func (k *constraintKeeper) get(blob []byte) *keyConstraints {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keys[string(blob)]
+}
+
+// set replaces constraints of the key, nil drops them.
+func (k *constraintKeeper) set(blob []byte, kc *keyConstraints) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if old, ok := k.keys[string(blob)]; ok {
+		old.stop()
+		delete(k.keys, string(blob))
+	}
+	if kc != nil {
+		k.keys[string(blob)] = kc
+	}
+}
+
+func (k *constraintKeeper) clear() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, kc := range k.keys {
+		kc.stop()
+	}
+	k.keys = make(map[string]*keyConstraints)
+}
+
+// expire removes key from the agent when its lifetime is over, unless constraints were replaced in the meantime.
+func (k *constraintKeeper) expire(blob []byte, kc *keyConstraints) {
+
+	k.mu.Lock()
+	if k.keys[string(blob)] != kc {
+		k.mu.Unlock()
+		return
+	}
+	delete(k.keys, string(blob))
+	k.mu.Unlock()
+
+	res, err := kc.agent.Query(frame(agentproto.Marshal(&agentproto.RemoveIdentity{KeyBlob: blob})))
+	if err == nil && !bytes.Equal(res, badResponse[:]) {
+		logging.Infof("Key %s expired", agentproto.Fingerprint(blob))
+		return
+	}
+	logging.Warnf("Unable to remove expired key %s from %s: %v", agentproto.Fingerprint(blob), kc.agent.Name(), err)
+}
+
+// add strips constraints proxy is able to enforce from the request, adds key to the agent with send and remembers
+// them.
+func (k *constraintKeeper) add(c *client, m *agentproto.AddIdentity, send func(*client, *agentproto.AddIdentity) (agentproto.Message, error)) ([]byte, error) {
+
+	blob, err := m.PublicKey()
+	if err != nil {
+		// let agent decide what to do with it
+		return c.up.query(frame(agentproto.Marshal(m)))
+	}
+
+	kc := &keyConstraints{}
+	var (
+		lifetime time.Duration
+		rest     []agentproto.Constraint
+	)
+	for _, con := range m.Constraints {
+		switch v := con.(type) {
+		case agentproto.LifetimeConstraint:
+			lifetime = time.Duration(v.Seconds) * time.Second
+		case agentproto.ConfirmConstraint:
+			kc.confirm = true
+		case agentproto.ExtensionConstraint:
+			if v.Name != agentproto.ExtRestrictDestination {
+				rest = append(rest, con)
+				break
+			}
+			dests, err := agentproto.ParseDestinationConstraints(v)
+			if err != nil {
+				return c.refuse("key addition: %s", err), nil
+			}
+			kc.dests = append(kc.dests, dests...)
+		default:
+			rest = append(rest, con)
+		}
+	}
+
+	add := *m
+	add.Constraints, add.Constrained = rest, len(rest) > 0
+	res, err := send(c, &add)
+	if err != nil {
+		return nil, err
+	}
+	if res.Type() != agentproto.AgentSuccess {
+		return frame(agentproto.Marshal(res)), nil
+	}
+
+	if lifetime == 0 && !kc.confirm && len(kc.dests) == 0 {
+		// key re-added without constraints loses old ones
+		k.set(blob, nil)
+		return frame(agentproto.Marshal(res)), nil
+	}
+	if lifetime > 0 {
+		kc.agent = c.up.agent
+		kc.expire = time.AfterFunc(lifetime, func() { k.expire(blob, kc) })
+	}
+	k.set(blob, kc)
+	logging.Infof("[%s] Enforcing constraints of key %s: lifetime %s, confirm %t, %d destination(s)",
+		c.handle, agentproto.Fingerprint(blob), lifetime, kc.confirm, len(kc.dests))
+	return frame(agentproto.Marshal(res)), nil
+}
+
+// permitted checks destination restrictions of the key against session bindings of the client connection the way
+// ssh-agent does it. Connection without bindings is local use and is always permitted. When data is nil only the path
+// is checked, otherwise it must be user authentication request for the last bound session.
+func (kc *keyConstraints) permitted(binds []*agentproto.SessionBind, data []byte) error {
+
+	if len(kc.dests) == 0 || len(binds) == 0 {
+		return nil
+	}
+
+	var user *string
+	last := binds[len(binds)-1]
+	if data != nil {
+		u, err := agentproto.ParseUserauthRequest(data)
+		if err != nil {
+			return fmt.Errorf("destination restricted key could only sign user authentication requests: %w", err)
+		}
+		if !bytes.Equal(u.SessionID, last.SessionID) {
+			return errors.New("user authentication request is for unexpected session")
+		}
+		if u.HostKey != nil && !sameKey(u.HostKey, last.HostKey) {
+			return errors.New("user authentication request is for unexpected host key")
+		}
+		if last.Forwarding {
+			return errors.New("user authentication on forwarding hop")
+		}
+		user = &u.User
+	}
+
+	for i, b := range binds {
+		var from []byte
+		if i > 0 {
+			from = binds[i-1].HostKey
+		}
+		var u *string
+		if i == len(binds)-1 {
+			u = user
+		}
+		if !kc.permittedHop(from, b.HostKey, u) {
+			return fmt.Errorf("key is not permitted for hop %d to %s", i, agentproto.Fingerprint(b.HostKey))
+		}
+	}
+	if last.Forwarding && !kc.permittedHop(last.HostKey, nil, nil) {
+		return fmt.Errorf("key is not permitted to be used from %s", agentproto.Fingerprint(last.HostKey))
+	}
+	return nil
+}
+
+// permittedHop reports if any destination constraint allows connection from one host to another. Nil from means
+// origin, nil to allows any destination.
+func (kc *keyConstraints) permittedHop(from, to []byte, user *string) bool {
+	for _, d := range kc.dests {
+		if from == nil {
+			if len(d.From.Hostname) != 0 || len(d.From.Keys) != 0 {
+				continue
+			}
+		} else if !matchHop(from, d.From) {
+			continue
+		}
+		if to != nil && !matchHop(to, d.To) {
+			continue
+		}
+		if len(d.To.User) != 0 && user != nil && !wildcardMatch(d.To.User, *user) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// matchHop reports if host key is one of hop keys or is a valid certificate issued by one of hop authorities.
+func matchHop(key []byte, hop agentproto.Hop) bool {
+	for _, hk := range hop.Keys {
+		if !hk.CA {
+			if sameKey(key, hk.Blob) {
+				return true
+			}
+			continue
+		}
+		cert, err := agentproto.ParseCertificate(key)
+		if err != nil || !sameKey(cert.SignatureKey, hk.Blob) {
+			continue
+		}
+		if cert.ValidFor(agentproto.HostCert, hop.Hostname, time.Now()) && cert.CheckSignature() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sameKey compares public keys ignoring certificates.
+func sameKey(a, b []byte) bool {
+	pa, err := agentproto.PlainKey(a)
+	if err != nil {
+		return false
+	}
+	pb, err := agentproto.PlainKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(pa, pb)
+}
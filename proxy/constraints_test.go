@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/keyring"
+)
+
+// downBackend could never be reached.
+func downBackend() Backend {
+	return NewBackend("down", func() (net.Conn, error) { return nil, errors.New("agent is down") })
+}
+
+func listed(t *testing.T, path string) map[string]bool {
+	t.Helper()
+	list, ok := call(t, path, &agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer)
+	if !ok {
+		t.Fatal("listing failed")
+	}
+	names := make(map[string]bool)
+	for _, id := range list.Keys {
+		names[id.Comment] = true
+	}
+	return names
+}
+
+func TestConstraintExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(kr *keyring.Keyring) Backend
+	}{
+		{name: "agent", backend: func(kr *keyring.Keyring) Backend { return NewLocalBackend(SchemeMemory+":", kr) }},
+		// key has to expire from the agent it was added to
+		{name: "fallback", backend: func(kr *keyring.Keyring) Backend {
+			return NewFallbackBackend(downBackend(), NewLocalBackend(SchemeMemory+":", kr))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr := keyring.New()
+			s := NewServer(tt.backend(kr), WithConstraints())
+			path := serve(t, s, ListenerOptions{})
+
+			expiring, kept := newKey(t, "expiring"), newKey(t, "kept")
+			expiring.Constrained = true
+			expiring.Constraints = []agentproto.Constraint{agentproto.LifetimeConstraint{Seconds: 1}}
+			for _, key := range []*agentproto.AddIdentity{expiring, kept} {
+				if got := call(t, path, key); !succeeded(got) {
+					t.Fatalf("add %s reply %s", key.Comment, got.Type())
+				}
+			}
+
+			// re-added without lifetime key must survive
+			readded := newKey(t, "re-added")
+			readded.Constrained = true
+			readded.Constraints = expiring.Constraints
+			call(t, path, readded)
+			readded.Constrained, readded.Constraints = false, nil
+			call(t, path, readded)
+
+			if kr.Len() != 3 {
+				t.Fatalf("agent holds %d keys, want 3", kr.Len())
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for kr.Len() > 2 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond) // re-added key was added a bit later
+			if names := listed(t, path); len(names) != 2 || names["expiring"] {
+				t.Errorf("identities after expiry %v, want kept and re-added", names)
+			}
+		})
+	}
+}
+
+func TestConstraintsStripped(t *testing.T) {
+	tests := []struct {
+		name        string
+		constraints []agentproto.Constraint
+		added       bool
+	}{
+		{name: "lifetime", constraints: []agentproto.Constraint{agentproto.LifetimeConstraint{Seconds: 60}}, added: true},
+		// keyring refuses confirmation, so it got there only without it
+		{name: "confirm", constraints: []agentproto.Constraint{agentproto.ConfirmConstraint{}}, added: true},
+		{name: "lifetime and confirm", constraints: []agentproto.Constraint{agentproto.LifetimeConstraint{Seconds: 60}, agentproto.ConfirmConstraint{}}, added: true},
+		// unknown constraint is passed through and keyring refuses it
+		{name: "unknown", constraints: []agentproto.Constraint{agentproto.ConfirmConstraint{}, agentproto.ExtensionConstraint{Name: "unknown@example.com"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kr := keyring.New()
+			s := NewServer(NewLocalBackend(SchemeMemory+":", kr), WithConstraints())
+			path := serve(t, s, ListenerOptions{})
+
+			key := newKey(t, "constrained")
+			key.Constrained, key.Constraints = true, tt.constraints
+			if got := call(t, path, key); succeeded(got) != tt.added {
+				t.Fatalf("add reply %s", got.Type())
+			}
+			want := 0
+			if tt.added {
+				want = 1
+			}
+			if kr.Len() != want {
+				t.Errorf("agent holds %d keys, want %d", kr.Len(), want)
+			}
+			if kc := s.constraints.get(blob(t, key)); (kc != nil) != tt.added {
+				t.Errorf("constraints %+v kept", kc)
+			}
+		})
+	}
+}
+
+func TestConstraintsAgentDown(t *testing.T) {
+	s := NewServer(downBackend(), WithConstraints())
+	path := serve(t, s, ListenerOptions{})
+
+	key := newKey(t, "constrained")
+	key.Constrained, key.Constraints = true, []agentproto.Constraint{agentproto.ConfirmConstraint{}}
+	for _, req := range []agentproto.Message{key, newKey(t, "plain"), &agentproto.RequestIdentities{}} {
+		if got := call(t, path, req); got.Type() != agentproto.AgentFailure {
+			t.Errorf("%s reply %s with agent down, want failure", req.Type(), got.Type())
+		}
+	}
+	if kc := s.constraints.get(blob(t, key)); kc != nil {
+		t.Errorf("constraints %+v kept for key agent did not get", kc)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/events"
+)
+
+// Backend health as last seen by requests.
+const (
+	backendUnknown int32 = iota
+	backendUp
+	backendDown
+)
+
+// WithEvents makes server publish connection, sign and backend events to sink.
+func WithEvents(sink events.Sink) Option {
+	return func(s *Server) {
+		s.events = sink
+	}
+}
+
+// connectionEvent publishes opening or closing of client connection.
+func (s *Server) connectionEvent(t events.Type, info *Connection) {
+	s.events.Publish(&events.Event{Type: t, Connection: info.ID, Listener: info.Listener, Remote: info.Remote})
+}
+
+// signRequested publishes sign request and returns event to be completed by signDone, nil for other requests.
+func (s *Server) signRequested(c *client, info *Connection, req []byte) *events.Event {
+	if agentproto.MessageType(req[4]) != agentproto.SignRequestMsg {
+		return nil
+	}
+	m, err := agentproto.Parse(req[4:])
+	if err != nil {
+		return nil
+	}
+	sign := m.(*agentproto.SignRequest)
+	e := &events.Event{
+		Type:       events.SignRequested,
+		Connection: info.ID,
+		Listener:   info.Listener,
+		Key:        agentproto.Fingerprint(sign.KeyBlob),
+		Host:       s.settings().hostName(c.signedData(sign.Data).HostKey),
+	}
+	s.events.Publish(e)
+	return e
+}
+
+// signDone publishes outcome of sign request.
+func (s *Server) signDone(req *events.Event, c *client, res []byte, err error) {
+	e := *req
+	e.Time = time.Time{}
+	switch {
+	case len(c.refused) > 0:
+		e.Type, e.Reason = events.SignDenied, c.refused
+	case err != nil:
+		e.Type, e.Reason = events.SignDenied, err.Error()
+	case len(res) > 4 && agentproto.MessageType(res[4]) == agentproto.SignResponseMsg:
+		e.Type = events.SignApproved
+	default:
+		e.Type, e.Reason = events.SignDenied, "agent failure"
+	}
+	s.events.Publish(&e)
+}
+
+// backendResult publishes change of backend health judged by outcome of request relayed to it.
+func (s *Server) backendResult(err error) {
+	state := backendUp
+	if err != nil {
+		state = backendDown
+	}
+	if s.health.Swap(state) == state {
+		return
+	}
+	e := &events.Event{Type: events.BackendUp, Backend: s.backend.Name()}
+	if err != nil {
+		e.Type, e.Reason = events.BackendDown, err.Error()
+	}
+	s.events.Publish(e)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wsl-ssh-agent/events"
+	"wsl-ssh-agent/knownhosts"
+	"wsl-ssh-agent/metrics"
+)
+
+// switchBackend is in-memory agent which could be taken down.
+func switchBackend(down *atomic.Bool) Backend {
+	agent := memoryBackend()
+	return NewBackend("switched", func() (net.Conn, error) {
+		if down.Load() {
+			return nil, errors.New("agent is down")
+		}
+		return agent.Dial()
+	})
+}
+
+// shippedOptions are options the program runs server with.
+func shippedOptions(bus *events.Bus, r *metrics.Registry) []Option {
+	return []Option{
+		WithApprover(NewDialogApprover("test"), time.Second),
+		WithHostPolicy(),
+		WithHosts(knownhosts.New()),
+		WithVersion("test"),
+		WithConstraints(),
+		WithEvents(bus),
+		WithMetrics(NewMetrics(r)),
+		WithCompat(),
+	}
+}
+
+// nextBackendEvent skips events not related to backend health.
+func nextBackendEvent(t *testing.T, sub *events.Subscription) *events.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.Type == events.BackendUp || e.Type == events.BackendDown {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("no backend event")
+		}
+	}
+}
+
+func TestBackendEvents(t *testing.T) {
+	var down atomic.Bool
+	bus := events.NewBus()
+	sub := bus.Subscribe()
+	defer sub.Close()
+	s := NewServer(switchBackend(&down), shippedOptions(bus, metrics.NewRegistry())...)
+	path := serve(t, s, ListenerOptions{})
+
+	for _, state := range []bool{false, true, false} {
+		down.Store(state)
+		call(t, path, newKey(t, "key"))
+		want := events.BackendUp
+		if state {
+			want = events.BackendDown
+		}
+		e := nextBackendEvent(t, sub)
+		if e.Type != want || e.Backend != "switched" {
+			t.Errorf("event %+v, want %s of switched", e, want)
+		}
+		if state && e.Reason == "" {
+			t.Error("backend down event has no reason")
+		}
+	}
+}
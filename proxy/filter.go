@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+// KeyFilter limits identities visible through a listener. Patterns are matched against key comment or against its
+// SHA256 fingerprint (when pattern starts with "SHA256:"), '*' and '?' are wildcards. Pattern prefixed with '!' denies
+// matching keys. Key is allowed when it matches no negated pattern and either matches one of the others or there are
+// only negated patterns.
+type KeyFilter struct {
+	allow, deny []string
+}
+
+// NewKeyFilter creates KeyFilter from patterns. Every pattern could be a comma separated list, like in ssh_config.
+func NewKeyFilter(patterns ...string) (*KeyFilter, error) {
+	f := &KeyFilter{}
+	for _, p := range patterns {
+		for _, p := range strings.Split(p, ",") {
+			p = strings.TrimSpace(p)
+			if neg := strings.TrimPrefix(p, "!"); neg != p {
+				if len(neg) == 0 {
+					return nil, errors.New("empty negated key pattern")
+				}
+				f.deny = append(f.deny, neg)
+			} else if len(p) > 0 {
+				f.allow = append(f.allow, p)
+			}
+		}
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return nil, errors.New("no key patterns")
+	}
+	return f, nil
+}
+
+// String returns patterns as comma separated list.
+func (f *KeyFilter) String() string {
+	list := append([]string{}, f.allow...)
+	for _, p := range f.deny {
+		list = append(list, "!"+p)
+	}
+	return strings.Join(list, ",")
+}
+
+// Allowed reports if identity passes the filter.
+func (f *KeyFilter) Allowed(id agentproto.Identity) bool {
+	fp := agentproto.Fingerprint(id.KeyBlob)
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if strings.HasPrefix(p, "SHA256:") {
+				if wildcardMatch(p, fp) {
+					return true
+				}
+			} else if wildcardMatch(p, id.Comment) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || matches(f.allow)
+}
+
+// wildcardMatch matches s against pattern where '*' is any sequence of characters and '?' is any single character.
+func wildcardMatch(pattern, s string) bool {
+	px, sx := 0, 0
+	star, back := -1, 0
+	for sx < len(s) {
+		switch {
+		case px < len(pattern) && (pattern[px] == '?' || pattern[px] == s[sx]):
+			px++
+			sx++
+		case px < len(pattern) && pattern[px] == '*':
+			star, back = px, sx
+			px++
+		case star >= 0:
+			back++
+			px, sx = star+1, back
+		default:
+			return false
+		}
+	}
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+	return px == len(pattern)
+}
+
+func (f *KeyFilter) check(up *upstream, blob []byte) error {
+	id, err := up.identity(blob)
+	if err != nil {
+		return err
+	}
+	if !f.Allowed(id) {
+		return fmt.Errorf("key %s (%s) is not allowed", agentproto.Fingerprint(blob), id.Comment)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+func TestKeyFilter(t *testing.T) {
+	work := agentproto.Identity{KeyBlob: agentproto.AppendBytes(agentproto.AppendString(nil, "ssh-ed25519"), make([]byte, 32)), Comment: "me@work"}
+	home := agentproto.Identity{KeyBlob: agentproto.AppendBytes(agentproto.AppendString(nil, "ssh-ed25519"), make([]byte, 31)), Comment: "me@home"}
+	workFP := agentproto.Fingerprint(work.KeyBlob)
+
+	tests := []struct {
+		name     string
+		patterns []string
+		work     bool
+		home     bool
+		err      bool
+	}{
+		{name: "all", patterns: []string{"*"}, work: true, home: true},
+		{name: "exact comment", patterns: []string{"me@work"}, work: true},
+		{name: "wildcard", patterns: []string{"*@work"}, work: true},
+		{name: "single character", patterns: []string{"me@?o?e"}, home: true},
+		{name: "list", patterns: []string{"*@work, *@home"}, work: true, home: true},
+		{name: "repeated", patterns: []string{"*@work", "*@home"}, work: true, home: true},
+		{name: "fingerprint", patterns: []string{workFP}, work: true},
+		{name: "fingerprint prefix", patterns: []string{workFP[:12] + "*"}, work: true},
+		{name: "fingerprint does not match comment", patterns: []string{"SHA256:*work"}},
+		{name: "negated only", patterns: []string{"!*@work"}, home: true},
+		{name: "negated wins", patterns: []string{"me@*,!*@home"}, work: true},
+		{name: "negated fingerprint", patterns: []string{"*", "!" + workFP}, home: true},
+		{name: "no match", patterns: []string{"me"}},
+		{name: "empty", patterns: []string{" , "}, err: true},
+		{name: "empty negated", patterns: []string{"!"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewKeyFilter(tt.patterns...)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("NewKeyFilter(%q) succeeded, want error", tt.patterns)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewKeyFilter(%q): %v", tt.patterns, err)
+			}
+			if got := f.Allowed(work); got != tt.work {
+				t.Errorf("%s allows %s: %t, want %t", f, work.Comment, got, tt.work)
+			}
+			if got := f.Allowed(home); got != tt.home {
+				t.Errorf("%s allows %s: %t, want %t", f, home.Comment, got, tt.home)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"wsl-ssh-agent/util"
+)
+
+// ErrProtocol is returned when peer violates ssh-agent message framing.
+var ErrProtocol = errors.New("agent protocol error")
+
+// readFrame reads single length prefixed message and returns it together with its length prefix. Clean end of stream
+// before message starts is reported as io.EOF and stream ending in the middle of message as io.ErrUnexpectedEOF, read
+// errors are passed through, only malformed messages are reported with ErrProtocol.
+func readFrame(r io.Reader) ([]byte, error) {
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("unable to read message length: %w", err)
+	}
+
+	l := binary.BigEndian.Uint32(lenBuf[:])
+	if l == 0 {
+		return nil, fmt.Errorf("%w: empty message", ErrProtocol)
+	}
+	if l > util.MaxAgentMsgLen {
+		return nil, fmt.Errorf("%w: message length %d exceeds maximum %d", ErrProtocol, l, util.MaxAgentMsgLen)
+	}
+
+	buf := make([]byte, 4+l)
+	copy(buf, lenBuf[:])
+	if _, err := io.ReadFull(r, buf[4:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("unable to read message body (%d bytes): %w", l, err)
+	}
+	return buf, nil
+}
+
+// frame prepends message payload with its length.
+func frame(payload []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload))), payload...)
+}
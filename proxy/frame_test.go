@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"wsl-ssh-agent/util"
+)
+
+func TestReadFrame(t *testing.T) {
+	header := func(l uint32) []byte { return binary.BigEndian.AppendUint32(nil, l) }
+	largest := append(header(util.MaxAgentMsgLen), make([]byte, util.MaxAgentMsgLen)...)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+		err   error
+	}{
+		{name: "message", input: frame([]byte{11}), want: frame([]byte{11})},
+		{name: "largest message", input: largest, want: largest},
+		{name: "end of stream", input: nil, err: io.EOF},
+		{name: "empty message", input: header(0), err: ErrProtocol},
+		{name: "oversized message", input: header(util.MaxAgentMsgLen + 1), err: ErrProtocol},
+		{name: "huge length", input: header(0xffffffff), err: ErrProtocol},
+		{name: "short header", input: []byte{0, 0}, err: io.ErrUnexpectedEOF},
+		{name: "header only", input: header(5), err: io.ErrUnexpectedEOF},
+		{name: "short body", input: append(header(5), 11), err: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFrame(bytes.NewReader(tt.input))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("readFrame error %v, want %v", err, tt.err)
+				}
+				if tt.err != ErrProtocol && errors.Is(err, ErrProtocol) {
+					t.Errorf("readFrame error %v is reported as protocol violation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readFrame: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("readFrame = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import "strings"
+
+// HostResolver finds names of the server by its host key, knownhosts.DB is one.
+type HostResolver interface {
+	Lookup(hostKey []byte) []string
+}
+
+// WithHosts makes server name hosts client connections are bound to in logs, prompts and audit records.
+func WithHosts(r HostResolver) Option {
+	return func(s *Server) {
+		s.set.hosts = r
+	}
+}
+
+// hostName returns comma separated names of the host, empty when unknown.
+func (st *settings) hostName(hostKey []byte) string {
+	if st.hosts == nil || hostKey == nil {
+		return ""
+	}
+	return strings.Join(st.hosts.Lookup(hostKey), ", ")
+}
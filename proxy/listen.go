@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wsl-ssh-agent/logging"
+)
+
+const (
+	tokenLen         = 32
+	handshakeTimeout = 5 * time.Second
+)
+
+// Listen creates Listener from URL. Supported forms are:
+//
+//	unix:/path/agent.sock                 - AF_UNIX socket, stale socket file is removed
+//	tcp:127.0.0.1:port[?token=path]       - loopback TCP, clients must send token from the file before first request,
+//	                                        by default file is tcp-port.token in user configuration directory
+//	npipe://./pipe/name                   - Windows named pipe accessible to current user only
+//
+// Any listener accepts "allow" parameter (repeatable) with key patterns, see KeyFilter. For example
+// unix:/home/user/work.sock?allow=*@work,SHA256:abc... exposes only matching identities. Parameter "readonly" (no value
+// or boolean) stops clients from adding, removing keys and locking agent.
+func Listen(uri string) (Listener, error) {
+
+	scheme, address := splitURL(uri)
+
+	var query url.Values
+	if i := strings.Index(address, "?"); i >= 0 {
+		var err error
+		// '+' is common in fingerprints and never means space here
+		if query, err = url.ParseQuery(strings.ReplaceAll(address[i+1:], "+", "%2B")); err != nil {
+			return nil, fmt.Errorf("bad listener parameters in %q: %w", uri, err)
+		}
+		address = address[:i]
+	}
+	for name := range query {
+		if name != "allow" && name != "readonly" && (name != "token" || scheme != SchemeTCP) {
+			return nil, fmt.Errorf("unknown listener parameter %q in %q", name, uri)
+		}
+	}
+
+	var opts ListenerOptions
+	if patterns, ok := query["allow"]; ok {
+		var err error
+		if opts.Keys, err = NewKeyFilter(patterns...); err != nil {
+			return nil, fmt.Errorf("bad key patterns in %q: %w", uri, err)
+		}
+	}
+	if _, ok := query["readonly"]; ok {
+		var err error
+		if v := query.Get("readonly"); len(v) == 0 {
+			opts.ReadOnly = true
+		} else if opts.ReadOnly, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("bad readonly value in %q: %w", uri, err)
+		}
+	}
+
+	ln, err := listen(scheme, address, query)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Keys != nil {
+		logging.Infof("Listener %s allows keys %s", ln.Name(), opts.Keys)
+	}
+	if opts.ReadOnly {
+		logging.Infof("Listener %s is read-only", ln.Name())
+	}
+	if opts != (ListenerOptions{}) {
+		return Restrict(ln, opts), nil
+	}
+	return ln, nil
+}
+
+func listen(scheme, address string, query url.Values) (Listener, error) {
+
+	switch scheme {
+	case SchemeUnix:
+		return listenUnix(address)
+	case SchemeTCP:
+		return listenTCP(address, query.Get("token"))
+	case SchemePipe:
+		name, err := pipeName(address)
+		if err != nil {
+			return nil, err
+		}
+		ln, err := listenPipe(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not open pipe %s: %w", name, err)
+		}
+		return NewListener(SchemePipe+":"+name, ln), nil
+	}
+	return nil, fmt.Errorf("unsupported listener scheme %q", scheme)
+}
+
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Name() string {
+	return SchemeUnix + ":" + l.path
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	// Just in case - should not be needed
+	_ = os.Remove(l.path)
+	return err
+}
+
+func listenUnix(path string) (Listener, error) {
+
+	if len(path) == 0 {
+		return nil, errors.New("socket path is missing")
+	}
+	if !filepath.IsAbs(path) {
+		return nil, errors.New("socket name must be absolute path")
+	}
+
+	_, err := os.Stat(path)
+	if err == nil || !os.IsNotExist(err) {
+		if err := unlink(path); err != nil {
+			return nil, fmt.Errorf("failed to unlink socket %s: %w", path, err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open socket %s: %w", path, err)
+	}
+	return &unixListener{Listener: ln, path: path}, nil
+}
+
+type tcpListener struct {
+	net.Listener
+	token     []byte
+	tokenPath string
+}
+
+func (l *tcpListener) Name() string {
+	return SchemeTCP + ":" + l.Addr().String()
+}
+
+func (l *tcpListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenConn{Conn: conn, token: l.token}, nil
+}
+
+func (l *tcpListener) Close() error {
+	err := l.Listener.Close()
+	_ = os.Remove(l.tokenPath)
+	return err
+}
+
+func listenTCP(address, tokenPath string) (Listener, error) {
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("bad tcp address %q: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("tcp listener must use loopback address, got %q", host)
+	}
+
+	if len(tokenPath) == 0 {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find token directory: %w", err)
+		}
+		dir = filepath.Join(dir, "wsl-ssh-agent")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("unable to create token directory: %w", err)
+		}
+		tokenPath = filepath.Join(dir, fmt.Sprintf("tcp-%s.token", port))
+	}
+	token := make([]byte, tokenLen)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("unable to generate token: %w", err)
+	}
+	token = []byte(hex.EncodeToString(token))
+	if err := writeToken(tokenPath, token); err != nil {
+		return nil, fmt.Errorf("unable to write token file %s: %w", tokenPath, err)
+	}
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = os.Remove(tokenPath)
+		return nil, fmt.Errorf("could not listen on %s: %w", address, err)
+	}
+	logging.Infof("TCP listener %s token is in %s", ln.Addr(), tokenPath)
+	return &tcpListener{Listener: ln, token: token, tokenPath: tokenPath}, nil
+}
+
+// writeToken creates token file readable by current user only. File left by previous run is replaced, file somebody
+// else owns is never touched.
+func writeToken(path string, token []byte) error {
+
+	if fi, err := os.Lstat(path); err == nil {
+		owned, err := ownedByUser(path, fi)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return errors.New("file exists and is not owned by current user")
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(token); err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// tokenConn verifies handshake token sent by client before letting any data through.
+type tokenConn struct {
+	net.Conn
+	token []byte
+	once  sync.Once
+	err   error
+}
+
+func (c *tokenConn) handshake() {
+
+	_ = c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer func() { _ = c.SetReadDeadline(time.Time{}) }()
+
+	got := make([]byte, len(c.token))
+	if _, err := io.ReadFull(c.Conn, got); err != nil {
+		c.err = fmt.Errorf("no handshake token: %w", err)
+		return
+	}
+	if subtle.ConstantTimeCompare(got, c.token) != 1 {
+		c.err = errors.New("bad handshake token")
+	}
+}
+
+func (c *tokenConn) Read(b []byte) (int, error) {
+	c.once.Do(c.handshake)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
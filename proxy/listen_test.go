@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTCPToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		stale bool // token file is left by previous run
+	}{
+		{name: "new"},
+		{name: "stale", stale: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agent.token")
+			if tt.stale {
+				if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ln, err := Listen("tcp:127.0.0.1:0?token=" + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			fi, err := os.Stat(path)
+			if err != nil || fi.Mode().Perm() != 0600 {
+				t.Fatalf("token file %v, %v", fi, err)
+			}
+			token, _ := os.ReadFile(path)
+			if len(token) != 2*tokenLen {
+				t.Fatalf("token %q", token)
+			}
+
+			go func() {
+				conn, err := net.Dial("tcp", ln.(*tcpListener).Addr().String())
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write(append(token, "data"...))
+			}()
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			got := make([]byte, 4)
+			if _, err := conn.Read(got); err != nil || !bytes.Equal(got, []byte("data")) {
+				t.Errorf("read %q, %v after handshake", got, err)
+			}
+
+			ln.Close()
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error("token file is left after Close")
+			}
+		})
+	}
+}
+
+func TestTCPTokenDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	ln, err := Listen("tcp:127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	path := ln.(*tcpListener).tokenPath
+	if !strings.HasPrefix(path, dir) || filepath.Base(filepath.Dir(path)) != "wsl-ssh-agent" {
+		t.Errorf("token path %s, want it in configuration directory %s", path, dir)
+	}
+}
+
+func TestTCPTokenUnwritable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.token")
+	// directory in place of the file
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(path, "keep"), nil, 0600)
+	if ln, err := Listen("tcp:127.0.0.1:0?token=" + path); err == nil {
+		ln.Close()
+		t.Fatal("Listen succeeded without token file")
+	}
+}
+
+func TestTCPTokenForeign(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("giving file away needs root")
+	}
+	path := filepath.Join(t.TempDir(), "agent.token")
+	if err := os.WriteFile(path, []byte("theirs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(path, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := Listen("tcp:127.0.0.1:0?token=" + path); err == nil {
+		ln.Close()
+		t.Fatal("Listen replaced token file of another user")
+	}
+	if got, _ := os.ReadFile(path); string(got) != "theirs" {
+		t.Errorf("token file of another user changed to %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/metrics"
+)
+
+// Reasons requests fail, values of "reason" label.
+const (
+	failProtocol      = "protocol"       // malformed request, connection is dropped
+	failSessionLocked = "session-locked" // user session is locked
+	failProxyLocked   = "proxy-locked"   // by lock@wsl-ssh-agent
+	failAccess        = "access-limited" // by session policy
+	failRefused       = "refused"        // by listener restrictions, constraints, host policy or confirmation
+	failBackend       = "backend-error"  // talking to agent failed
+	failAgent         = "agent-failure"  // agent replied with failure
+)
+
+// Metrics are measurements server keeps, they are registered with metrics.Registry by NewMetrics.
+type Metrics struct {
+	requests    *metrics.CounterVec
+	failures    *metrics.CounterVec
+	signLatency *metrics.HistogramVec
+	connections *metrics.Gauge
+	dialErrors  *metrics.CounterVec
+	lockDenials *metrics.CounterVec
+}
+
+// NewMetrics registers server metrics.
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests:    r.Counter("wsl_ssh_agent_requests_total", "Agent requests by message type.", "type"),
+		failures:    r.Counter("wsl_ssh_agent_failures_total", "Failed agent requests by reason.", "reason"),
+		signLatency: r.Histogram("wsl_ssh_agent_sign_duration_seconds", "Time backend agent takes to sign, by key fingerprint.", metrics.DefaultBuckets, "key"),
+		connections: r.Gauge("wsl_ssh_agent_connections", "Open client connections."),
+		dialErrors:  r.Counter("wsl_ssh_agent_dial_errors_total", "Failed attempts to connect to backend agent.", "backend"),
+		lockDenials: r.Counter("wsl_ssh_agent_lock_denials_total", "Requests refused because user session is locked."),
+	}
+}
+
+// WithMetrics makes server update m.
+func WithMetrics(m *Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+		watchDials(s.backend, func(name string) { m.dialErrors.Inc(name) })
+	}
+}
+
+// measure updates metrics with request outcome, kind is failure reason when proxy did not let request through.
+func (m *Metrics) measure(c *client, req, res []byte, err error, kind string) {
+
+	t := agentproto.MessageType(req[4])
+	m.requests.Inc(t.String())
+
+	switch {
+	case len(kind) > 0:
+		m.failures.Inc(kind)
+		if kind == failSessionLocked {
+			m.lockDenials.Inc()
+		}
+		return
+	case len(c.refused) > 0:
+		m.failures.Inc(failRefused)
+		return
+	case err != nil:
+		m.failures.Inc(failBackend)
+		return
+	case len(res) > 4 && (res[4] == byte(agentproto.AgentFailure) || res[4] == byte(agentproto.ExtensionFailureMsg)):
+		m.failures.Inc(failAgent)
+		return
+	}
+
+	if t == agentproto.SignRequestMsg {
+		if sign, err := agentproto.Parse(req[4:]); err == nil {
+			m.signLatency.Observe(c.up.waited.Seconds(), agentproto.Fingerprint(sign.(*agentproto.SignRequest).KeyBlob))
+		}
+	}
+}
+
+// connection tracks number of open client connections.
+func (m *Metrics) connection(open bool) {
+	if open {
+		m.connections.Add(1)
+	} else {
+		m.connections.Add(-1)
+	}
+}
+
+// protocolError counts misbehaving client.
+func (m *Metrics) protocolError() {
+	m.failures.Inc(failProtocol)
+}
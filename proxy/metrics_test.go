@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/events"
+	"wsl-ssh-agent/metrics"
+)
+
+func TestLockDenials(t *testing.T) {
+	tests := []struct {
+		name    string
+		access  Access
+		byLock  bool
+		signed  bool
+		denials string
+	}{
+		{name: "full access", access: AccessFull, signed: true, denials: "0"},
+		{name: "session locked", access: AccessNone, byLock: true, denials: "1"},
+		{name: "session locked list only", access: AccessList, byLock: true, denials: "1"},
+		{name: "limited by other condition", access: AccessList, denials: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := metrics.NewRegistry()
+			s := NewServer(memoryBackend(), WithMetrics(NewMetrics(r)))
+			path := serve(t, s, ListenerOptions{})
+
+			key := newKey(t, "c-ed25519")
+			if got := call(t, path, key); !succeeded(got) {
+				t.Fatalf("add reply %s", got.Type())
+			}
+			s.SetAccess(tt.access, tt.byLock)
+			if got := call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, key), Data: []byte("data")}); succeeded(got) != tt.signed {
+				t.Errorf("sign reply %s, want success %t", got.Type(), tt.signed)
+			}
+
+			want := "wsl_ssh_agent_lock_denials_total " + tt.denials + "\n"
+			if text := r.Text(); !strings.Contains(text, want) {
+				t.Errorf("metrics do not contain %q:\n%s", want, text)
+			}
+		})
+	}
+}
+
+func TestBackendFailures(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	r := metrics.NewRegistry()
+	s := NewServer(switchBackend(&down), shippedOptions(events.NewBus(), r)...)
+	path := serve(t, s, ListenerOptions{})
+
+	key := newKey(t, "c-ed25519")
+	key.Constrained, key.Constraints = true, []agentproto.Constraint{agentproto.LifetimeConstraint{Seconds: 60}}
+	for _, req := range []agentproto.Message{&agentproto.RequestIdentities{}, key} {
+		if got := call(t, path, req); got.Type() != agentproto.AgentFailure {
+			t.Errorf("%s reply %s with agent down, want failure", req.Type(), got.Type())
+		}
+	}
+
+	text := r.Text()
+	for _, want := range []string{
+		`wsl_ssh_agent_failures_total{reason="backend-error"} 2` + "\n",
+		`wsl_ssh_agent_dial_errors_total{backend="switched"}`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, text)
+		}
+	}
+}
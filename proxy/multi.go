@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+type multiBackend struct {
+	backends []Backend
+
+	mu     sync.Mutex
+	owners map[string]int // key blob -> index of backend holding it
+}
+
+// NewMultiBackend returns Backend which aggregates several agents. Identities of all reachable agents are merged in
+// order with duplicates removed, signing and key removal requests are routed to the agent holding the key, new keys
+// are added to the first agent accepting them and lock, unlock and remove all requests go to every agent and succeed
+// only when every agent accepts them.
+func NewMultiBackend(backends ...Backend) Backend {
+	return &multiBackend{backends: backends, owners: make(map[string]int)}
+}
+
+func (b *multiBackend) Name() string {
+	names := make([]string, 0, len(b.backends))
+	for _, be := range b.backends {
+		names = append(names, be.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func (b *multiBackend) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	mc := &multiConn{multi: b, ups: make([]*upstream, len(b.backends))}
+	for i, be := range b.backends {
+		mc.ups[i] = newUpstream(be)
+	}
+	go func() {
+		serveHandler(server, mc)
+		mc.close()
+	}()
+	return &multiPipe{Conn: client, mc: mc}, nil
+}
+
+func (b *multiBackend) Query(req []byte) ([]byte, error) {
+	return dialQuery(b, req)
+}
+
+func (b *multiBackend) watchDials(failed func(name string)) {
+	for _, be := range b.backends {
+		watchDials(be, failed)
+	}
+}
+
+func (b *multiBackend) Clear() {
+	for _, be := range b.backends {
+		if c, ok := be.(Clearer); ok {
+			c.Clear()
+		}
+	}
+}
+
+func (b *multiBackend) owner(blob []byte) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i, ok := b.owners[string(blob)]
+	return i, ok
+}
+
+// setOwners replaces ownership of all keys, nil forgets them.
+func (b *multiBackend) setOwners(owners map[string]int) {
+	if owners == nil {
+		owners = make(map[string]int)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.owners = owners
+}
+
+func (b *multiBackend) dropOwner(blob []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.owners, string(blob))
+}
+
+// multiConn keeps upstream connections to every backend for a single client connection.
+type multiConn struct {
+	multi *multiBackend
+	ups   []*upstream
+
+	mu  sync.Mutex
+	err error // backend failure which closed connection
+}
+
+func (c *multiConn) close() {
+	for _, up := range c.ups {
+		up.Close()
+	}
+}
+
+func (c *multiConn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// multiPipe is client end of multiConn, it reports backend failure which made multiConn drop connection.
+type multiPipe struct {
+	net.Conn
+	mc *multiConn
+}
+
+func (p *multiPipe) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	if errors.Is(err, io.EOF) {
+		if ferr := p.mc.failure(); ferr != nil {
+			err = ferr
+		}
+	}
+	return n, err
+}
+
+// ask sends request to a single backend.
+func (c *multiConn) ask(i int, req agentproto.Message) (agentproto.Message, error) {
+	res, err := c.ups[i].queryMessage(req)
+	if err != nil {
+		logging.Warnf("Query to %s failed: %s", c.ups[i].backend.Name(), err)
+	}
+	return res, err
+}
+
+func failed(m agentproto.Message) bool {
+	t := m.Type()
+	return t == agentproto.AgentFailure || t == agentproto.ExtensionFailureMsg
+}
+
+// Handle answers request, backend failure drops connection and is reported by multiPipe like failure of a single
+// agent would be.
+func (c *multiConn) Handle(req agentproto.Message) agentproto.Message {
+	res, err := c.handle(req)
+	if err != nil {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		return nil
+	}
+	return res
+}
+
+func (c *multiConn) handle(req agentproto.Message) (agentproto.Message, error) {
+
+	switch m := req.(type) {
+	case *agentproto.RequestIdentities:
+		return c.identities()
+	case *agentproto.SignRequest:
+		return c.route(m.KeyBlob, req)
+	case *agentproto.RemoveIdentity:
+		res, err := c.route(m.KeyBlob, req)
+		if err == nil && !failed(res) {
+			c.multi.dropOwner(m.KeyBlob)
+		}
+		return res, err
+	case *agentproto.RemoveAllIdentities:
+		res, err := c.every(req, nil)
+		if err == nil {
+			// some keys could be gone even when request failed
+			c.multi.setOwners(nil)
+		}
+		return res, err
+	case *agentproto.Lock:
+		return c.every(req, &agentproto.Unlock{Passphrase: m.Passphrase})
+	case *agentproto.Unlock:
+		return c.every(req, &agentproto.Lock{Passphrase: m.Passphrase})
+	case *agentproto.Extension:
+		if m.Name == agentproto.ExtSessionBind {
+			return c.broadcast(req)
+		}
+	}
+	return c.first(req)
+}
+
+// identities merges keys from all reachable backends and records which backend holds each of them, keys gone from
+// backends are forgotten. It fails when no backend could be reached.
+func (c *multiConn) identities() (agentproto.Message, error) {
+
+	res := &agentproto.IdentitiesAnswer{}
+	owners := make(map[string]int)
+	var errs []error
+	for i := range c.ups {
+		m, err := c.ask(i, &agentproto.RequestIdentities{})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ans, ok := m.(*agentproto.IdentitiesAnswer)
+		if !ok {
+			continue
+		}
+		for _, id := range ans.Keys {
+			if _, dup := owners[string(id.KeyBlob)]; dup {
+				continue
+			}
+			owners[string(id.KeyBlob)] = i
+			res.Keys = append(res.Keys, id)
+		}
+	}
+	if len(errs) == len(c.ups) {
+		return nil, errors.Join(errs...)
+	}
+	c.multi.setOwners(owners)
+	return res, nil
+}
+
+// route sends request to the backend holding the key.
+func (c *multiConn) route(blob []byte, req agentproto.Message) (agentproto.Message, error) {
+
+	i, ok := c.multi.owner(blob)
+	if !ok {
+		// client did not ask for identities first or keys were changed behind our back
+		if _, err := c.identities(); err != nil {
+			return nil, err
+		}
+		if i, ok = c.multi.owner(blob); !ok {
+			return &agentproto.Failure{}, nil
+		}
+	}
+	return c.ask(i, req)
+}
+
+// broadcast sends request to every backend and succeeds if any of them does. It fails when no backend could be
+// reached.
+func (c *multiConn) broadcast(req agentproto.Message) (agentproto.Message, error) {
+	var (
+		res  agentproto.Message = &agentproto.Failure{}
+		errs []error
+	)
+	for i := range c.ups {
+		r, err := c.ask(i, req)
+		if err != nil {
+			errs = append(errs, err)
+		} else if !failed(r) {
+			res = r
+		}
+	}
+	if len(errs) == len(c.ups) {
+		return nil, errors.Join(errs...)
+	}
+	return res, nil
+}
+
+// every sends request to every backend and succeeds only when all of them do. When some backend fails or could not
+// be reached undo (unless nil) is sent to backends which already accepted request. It fails when no backend could be
+// reached.
+func (c *multiConn) every(req, undo agentproto.Message) (agentproto.Message, error) {
+	var (
+		done []int
+		errs []error
+	)
+	for i := range c.ups {
+		r, err := c.ask(i, req)
+		if err != nil {
+			errs = append(errs, err)
+		} else if !failed(r) {
+			done = append(done, i)
+		}
+	}
+	if len(errs) == len(c.ups) {
+		return nil, errors.Join(errs...)
+	}
+	if len(done) == len(c.ups) {
+		return &agentproto.Success{}, nil
+	}
+	if undo != nil {
+		for _, i := range done {
+			if r, err := c.ask(i, undo); err == nil && failed(r) {
+				logging.Warnf("Unable to undo %s on %s", req.Type(), c.ups[i].backend.Name())
+			}
+		}
+	}
+	return &agentproto.Failure{}, nil
+}
+
+// first returns reply of the first backend which does not fail the request. It fails when no backend could be
+// reached.
+func (c *multiConn) first(req agentproto.Message) (agentproto.Message, error) {
+	var (
+		res  agentproto.Message = &agentproto.Failure{}
+		errs []error
+	)
+	for i := range c.ups {
+		r, err := c.ask(i, req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if res = r; !failed(res) {
+			break
+		}
+	}
+	if len(errs) == len(c.ups) {
+		return nil, errors.Join(errs...)
+	}
+	return res, nil
+}
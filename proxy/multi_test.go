@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/keyring"
+)
+
+func TestMultiBackend(t *testing.T) {
+	first, second := keyring.New(), keyring.New()
+	s := NewServer(NewMultiBackend(NewLocalBackend("first", first), NewLocalBackend("second", second)))
+	path := serve(t, s, ListenerOptions{})
+
+	key1, key2 := newKey(t, "first"), newKey(t, "second")
+	if got := call(t, path, key1); !succeeded(got) {
+		t.Fatalf("add reply %s", got.Type())
+	}
+	// behind proxy back, like ssh-add run directly against the agent
+	if got := second.Handle(key2); !succeeded(got) {
+		t.Fatalf("add reply %s", got.Type())
+	}
+	if first.Len() != 1 || second.Len() != 1 {
+		t.Fatalf("agents hold %d and %d keys, want 1 and 1", first.Len(), second.Len())
+	}
+
+	list, ok := call(t, path, &agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer)
+	if !ok || len(list.Keys) != 2 || list.Keys[0].Comment != "first" || list.Keys[1].Comment != "second" {
+		t.Fatalf("identities %+v, want first and second", list)
+	}
+
+	tests := []struct {
+		name string
+		key  *agentproto.AddIdentity
+	}{
+		{name: "first agent", key: key1},
+		{name: "second agent", key: key2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, tt.key), Data: []byte("data")})
+			if _, ok := got.(*agentproto.SignResponse); !ok {
+				t.Errorf("sign reply %s, want signature", got.Type())
+			}
+		})
+	}
+
+	if got := call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, newKey(t, "unknown")), Data: []byte("data")}); succeeded(got) {
+		t.Errorf("sign with unknown key reply %s, want failure", got.Type())
+	}
+
+	if got := call(t, path, &agentproto.RemoveIdentity{KeyBlob: blob(t, key2)}); !succeeded(got) {
+		t.Errorf("remove reply %s", got.Type())
+	}
+	if first.Len() != 1 || second.Len() != 0 {
+		t.Errorf("after removal agents hold %d and %d keys, want 1 and 0", first.Len(), second.Len())
+	}
+
+	second.Handle(key2)
+	if got := call(t, path, &agentproto.RemoveAllIdentities{}); !succeeded(got) {
+		t.Errorf("remove all reply %s", got.Type())
+	}
+	if first.Len() != 0 || second.Len() != 0 {
+		t.Errorf("after removal of all keys agents hold %d and %d keys", first.Len(), second.Len())
+	}
+}
+
+func TestMultiBackendOwners(t *testing.T) {
+	first, second := keyring.New(), keyring.New()
+	multi := NewMultiBackend(NewLocalBackend("first", first), NewLocalBackend("second", second)).(*multiBackend)
+	path := serve(t, NewServer(multi), ListenerOptions{})
+	owners := func() int {
+		multi.mu.Lock()
+		defer multi.mu.Unlock()
+		return len(multi.owners)
+	}
+
+	key1, key2 := newKey(t, "first"), newKey(t, "second")
+	first.Handle(key1)
+	second.Handle(key2)
+	call(t, path, &agentproto.RequestIdentities{})
+	if n := owners(); n != 2 {
+		t.Fatalf("%d owners after listing, want 2", n)
+	}
+
+	call(t, path, &agentproto.RemoveIdentity{KeyBlob: blob(t, key1)})
+	if n := owners(); n != 1 {
+		t.Errorf("%d owners after removal, want 1", n)
+	}
+
+	// key moved to another agent behind proxy back
+	second.Handle(&agentproto.RemoveIdentity{KeyBlob: blob(t, key2)})
+	first.Handle(key2)
+	call(t, path, &agentproto.RequestIdentities{})
+	if i, ok := multi.owner(blob(t, key2)); !ok || i != 0 {
+		t.Errorf("owner of moved key %d, %t, want first", i, ok)
+	}
+	got := call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, key2), Data: []byte("data")})
+	if _, ok := got.(*agentproto.SignResponse); !ok {
+		t.Errorf("sign with moved key reply %s, want signature", got.Type())
+	}
+
+	call(t, path, &agentproto.RemoveAllIdentities{})
+	if n := owners(); n != 0 {
+		t.Errorf("%d owners after removal of all keys, want 0", n)
+	}
+}
+
+func TestMultiBackendDown(t *testing.T) {
+	up := keyring.New()
+	up.Handle(newKey(t, "up"))
+	tests := []struct {
+		name     string
+		backends []Backend
+		keys     int // -1 for failure
+	}{
+		{name: "one down", backends: []Backend{downBackend(), NewLocalBackend("up", up)}, keys: 1},
+		{name: "all down", backends: []Backend{downBackend(), downBackend()}, keys: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(NewMultiBackend(tt.backends...))
+			path := serve(t, s, ListenerOptions{})
+
+			got := call(t, path, &agentproto.RequestIdentities{})
+			list, ok := got.(*agentproto.IdentitiesAnswer)
+			if tt.keys < 0 {
+				if ok {
+					t.Errorf("identities %+v, want failure", list)
+				}
+			} else if !ok || len(list.Keys) != tt.keys {
+				t.Errorf("identities reply %s, want %d keys", got.Type(), tt.keys)
+			}
+
+			st := s.Status().Backend
+			if st.OK != (tt.keys >= 0) {
+				t.Errorf("backend status %+v", st)
+			}
+			if !st.OK && !strings.Contains(st.Error, "agent is down") {
+				t.Errorf("backend error %q does not tell why", st.Error)
+			}
+		})
+	}
+}
+
+func TestMultiBackendLock(t *testing.T) {
+	lock := func(pass string) agentproto.Message { return &agentproto.Lock{Passphrase: []byte(pass)} }
+	unlock := func(pass string) agentproto.Message { return &agentproto.Unlock{Passphrase: []byte(pass)} }
+	// locked agent pretends to be empty
+	locked := func(kr *keyring.Keyring) bool {
+		ans, ok := kr.Handle(&agentproto.RequestIdentities{}).(*agentproto.IdentitiesAnswer)
+		return ok && len(ans.Keys) == 0
+	}
+
+	tests := []struct {
+		name    string
+		second  agentproto.Message // sent to second agent directly first
+		req     agentproto.Message
+		success bool
+		locked  bool // state of the first agent afterwards
+	}{
+		{name: "lock", req: lock("pass"), success: true, locked: true},
+		{name: "lock refused by locked agent", second: lock("other"), req: lock("pass")},
+		{name: "unlock", second: lock("pass"), req: unlock("pass"), success: true},
+		{name: "unlock refused with other passphrase", second: lock("other"), req: unlock("pass"), locked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := keyring.New(), keyring.New()
+			first.Handle(newKey(t, "first"))
+			second.Handle(newKey(t, "second"))
+			if _, ok := tt.req.(*agentproto.Unlock); ok {
+				first.Handle(lock("pass"))
+			}
+			if tt.second != nil {
+				second.Handle(tt.second)
+			}
+			path := serve(t, NewServer(NewMultiBackend(NewLocalBackend("first", first), NewLocalBackend("second", second))), ListenerOptions{})
+
+			if got := call(t, path, tt.req); succeeded(got) != tt.success {
+				t.Errorf("%s reply %s, want success %t", tt.req.Type(), got.Type(), tt.success)
+			}
+			if locked(first) != tt.locked {
+				t.Errorf("first agent locked %t, want %t", locked(first), tt.locked)
+			}
+		})
+	}
+}
+
+func TestMultiBackendRemoveAllPartial(t *testing.T) {
+	first, second := keyring.New(), keyring.New()
+	first.Handle(newKey(t, "first"))
+	second.Handle(newKey(t, "second"))
+	second.Handle(&agentproto.Lock{Passphrase: []byte("pass")})
+	path := serve(t, NewServer(NewMultiBackend(NewLocalBackend("first", first), NewLocalBackend("second", second))), ListenerOptions{})
+
+	if got := call(t, path, &agentproto.RemoveAllIdentities{}); succeeded(got) {
+		t.Errorf("remove all reply %s with locked agent, want failure", got.Type())
+	}
+}
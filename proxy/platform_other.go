@@ -0,0 +1,39 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+func dialPipe(string) (net.Conn, error) {
+	return nil, errors.New("named pipes are only supported on Windows")
+}
+
+func listenPipe(string) (net.Listener, error) {
+	return nil, errors.New("named pipes are only supported on Windows")
+}
+
+func unlink(path string) error {
+	return os.Remove(path)
+}
+
+// ownedByUser reports if file belongs to the user program runs as.
+func ownedByUser(_ string, fi os.FileInfo) (bool, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, errors.New("file owner is unknown")
+	}
+	return int(st.Uid) == os.Getuid(), nil
+}
+
+// NewDialogApprover returns Approver which fails as message boxes are only available on Windows.
+func NewDialogApprover(string) Approver {
+	return ApproverFunc(func(context.Context, *Approval) (bool, error) {
+		return false, errors.New("confirmation dialogs are only supported on Windows")
+	})
+}
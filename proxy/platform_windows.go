@@ -0,0 +1,59 @@
+//go:build windows
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+
+	"wsl-ssh-agent/util"
+)
+
+func dialPipe(pipeName string) (net.Conn, error) {
+	return winio.DialPipe(pipeName, nil)
+}
+
+// Only owner (and system) could connect to pipes we create.
+const pipeSecurity = "D:P(A;;GA;;;SY)(A;;GA;;;OW)"
+
+func listenPipe(pipeName string) (net.Listener, error) {
+	return winio.ListenPipe(pipeName, &winio.PipeConfig{SecurityDescriptor: pipeSecurity})
+}
+
+// AF_UNIX socket files on Windows could not be removed with os.Remove.
+func unlink(path string) error {
+	return windows.Unlink(path)
+}
+
+// ownedByUser reports if file belongs to the user program runs as.
+func ownedByUser(path string, _ os.FileInfo) (bool, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return false, err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return false, err
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return false, err
+	}
+	return owner.Equals(user.User.Sid), nil
+}
+
+// NewDialogApprover returns Approver asking user with Yes/No message box. Dialog closes itself when request times out.
+func NewDialogApprover(title string) Approver {
+	return ApproverFunc(func(ctx context.Context, a *Approval) (bool, error) {
+		timeout := DefaultApprovalTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		return util.ShowYesNoMessage(title, a.Prompt(), timeout), nil
+	})
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+// HostPolicy limits hosts keys could sign user authentication for.
+type HostPolicy struct {
+	keys        *KeyFilter
+	allow, deny []string
+}
+
+// NewHostPolicy parses rule "keys=hosts" where keys are KeyFilter patterns and hosts is a comma separated list of host
+// name patterns, pattern prefixed with '!' denies matching hosts. Host names come from HostResolver, patterns
+// starting with "SHA256:" are matched against host key fingerprint instead. Names are only as trustworthy as files
+// resolver reads, fingerprints do not depend on them.
+func NewHostPolicy(rule string) (*HostPolicy, error) {
+	keys, hosts, ok := strings.Cut(rule, "=")
+	if !ok {
+		return nil, fmt.Errorf("host policy %q is not in keys=hosts form", rule)
+	}
+	f, err := NewKeyFilter(keys)
+	if err != nil {
+		return nil, fmt.Errorf("host policy %q: %w", rule, err)
+	}
+	p := &HostPolicy{keys: f}
+	for _, h := range strings.Split(hosts, ",") {
+		h = strings.TrimSpace(h)
+		if neg := strings.TrimPrefix(h, "!"); neg != h {
+			if len(neg) == 0 {
+				return nil, fmt.Errorf("host policy %q: empty negated host pattern", rule)
+			}
+			p.deny = append(p.deny, neg)
+		} else if len(h) > 0 {
+			p.allow = append(p.allow, h)
+		}
+	}
+	if len(p.allow) == 0 && len(p.deny) == 0 {
+		return nil, fmt.Errorf("host policy %q: no host patterns", rule)
+	}
+	return p, nil
+}
+
+// String returns policy rule.
+func (p *HostPolicy) String() string {
+	list := append([]string{}, p.allow...)
+	for _, h := range p.deny {
+		list = append(list, "!"+h)
+	}
+	return p.keys.String() + "=" + strings.Join(list, ",")
+}
+
+// Permits reports if host known under names with host key fingerprint fp passes the policy.
+func (p *HostPolicy) Permits(names []string, fp string) bool {
+	matches := func(patterns []string) bool {
+		for _, pat := range patterns {
+			if strings.HasPrefix(pat, "SHA256:") {
+				if wildcardMatch(pat, fp) {
+					return true
+				}
+				continue
+			}
+			for _, name := range names {
+				if wildcardMatch(strings.ToLower(pat), strings.ToLower(name)) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if matches(p.deny) {
+		return false
+	}
+	return len(p.allow) == 0 || matches(p.allow)
+}
+
+// WithHostPolicy makes server refuse signing with keys covered by policies unless request is user authentication to
+// the host every such policy permits. Destination is known only from session bindings of recent OpenSSH clients,
+// requests without one are refused.
+func WithHostPolicy(policies ...*HostPolicy) Option {
+	return func(s *Server) {
+		s.set.policies = append(s.set.policies, policies...)
+	}
+}
+
+// WithNoForwarding makes server refuse signing with keys passing filter on forwarded agent connections.
+func WithNoForwarding(keys *KeyFilter) Option {
+	return func(s *Server) {
+		s.set.noForwarding = keys
+	}
+}
+
+// checkPolicy applies host policies and forwarding restriction to sign request with identity.
+func (st *settings) checkPolicy(c *client, id agentproto.Identity, d *agentproto.SignedData) error {
+
+	if st.noForwarding != nil && st.noForwarding.Allowed(id) {
+		for _, b := range c.binds {
+			if b.Forwarding {
+				return errors.New("key could not be used on forwarded connection")
+			}
+		}
+	}
+
+	for _, p := range st.policies {
+		if !p.keys.Allowed(id) {
+			continue
+		}
+		if d.Kind != agentproto.SignedUserauth || d.HostKey == nil {
+			return fmt.Errorf("key is limited by policy %s, destination of %s is unknown", p, d)
+		}
+		var names []string
+		if st.hosts != nil {
+			names = st.hosts.Lookup(d.HostKey)
+		}
+		if !p.Permits(names, agentproto.Fingerprint(d.HostKey)) {
+			host := strings.Join(names, ", ")
+			if len(host) == 0 {
+				host = agentproto.Fingerprint(d.HostKey)
+			}
+			return fmt.Errorf("host %s is not permitted by policy %s", host, p)
+		}
+	}
+	return nil
+}
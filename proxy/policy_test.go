@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"crypto/sha512"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+// sshsig returns data ssh-keygen -Y sign asks agent to sign.
+func sshsig(namespace string, message []byte) []byte {
+	h := sha512.Sum512(message)
+	b := append([]byte("SSHSIG"), agentproto.AppendString(nil, namespace)...)
+	b = agentproto.AppendString(b, "")
+	b = agentproto.AppendString(b, "sha512")
+	return agentproto.AppendBytes(b, h[:])
+}
+
+func TestHostPolicyRefusesSSHSig(t *testing.T) {
+	policy, err := NewHostPolicy("covered=github.com,SHA256:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(memoryBackend(), WithHostPolicy(policy))
+	path := serve(t, s, ListenerOptions{})
+
+	covered, free := newKey(t, "covered"), newKey(t, "free")
+	for _, key := range []*agentproto.AddIdentity{covered, free} {
+		if got := call(t, path, key); !succeeded(got) {
+			t.Fatalf("add %s reply %s", key.Comment, got.Type())
+		}
+	}
+
+	tests := []struct {
+		name   string
+		key    *agentproto.AddIdentity
+		data   []byte
+		signed bool
+	}{
+		{name: "git commit with covered key", key: covered, data: sshsig("git", []byte("tree 0000"))},
+		{name: "file with covered key", key: covered, data: sshsig("file", []byte("data"))},
+		{name: "unknown data with covered key", key: covered, data: []byte("data")},
+		{name: "git commit with other key", key: free, data: sshsig("git", []byte("tree 0000")), signed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := call(t, path, &agentproto.SignRequest{KeyBlob: blob(t, tt.key), Data: tt.data})
+			if _, ok := got.(*agentproto.SignResponse); ok != tt.signed {
+				t.Errorf("sign reply %s, want signature %t", got.Type(), tt.signed)
+			}
+		})
+	}
+}
+
+func TestHostPolicyPermits(t *testing.T) {
+	const fp = "SHA256:kmYcvdi2GkPeWxB6XLjrZB8JHsy2Hm8luHMFp9GMvqk"
+	tests := []struct {
+		rule  string
+		names []string
+		want  bool
+	}{
+		{rule: "*=github.com", names: []string{"GitHub.com"}, want: true},
+		{rule: "*=github.com", names: []string{"gitlab.com"}},
+		{rule: "*=github.com", names: nil},
+		{rule: "*=*.example.com,!bad.example.com", names: []string{"bad.example.com"}},
+		{rule: "*=" + fp, names: nil, want: true},
+		{rule: "*=SHA256:kmYc*", names: []string{"other"}, want: true},
+		{rule: "*=!" + fp, names: []string{"github.com"}},
+		{rule: "*=SHA256:other", names: []string{"SHA256:other"}},
+	}
+	for _, tt := range tests {
+		p, err := NewHostPolicy(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Permits(tt.names, fp); got != tt.want {
+			t.Errorf("%s permits %v: %t, want %t", tt.rule, tt.names, got, tt.want)
+		}
+	}
+}
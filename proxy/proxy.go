@@ -0,0 +1,274 @@
+// Package proxy relays ssh-agent protocol requests from local client connections to an upstream agent.
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wsl-ssh-agent/audit"
+	"wsl-ssh-agent/events"
+	"wsl-ssh-agent/logging"
+)
+
+var badResponse = [...]byte{0, 0, 0, 1, 5}
+
+// Listener is source of client connections. Its Name is used in logs and diagnostics.
+type Listener interface {
+	net.Listener
+	Name() string
+}
+
+type namedListener struct {
+	net.Listener
+	name string
+}
+
+func (l *namedListener) Name() string {
+	return l.name
+}
+
+// NewListener gives name to an existing net.Listener.
+func NewListener(name string, ln net.Listener) Listener {
+	return &namedListener{Listener: ln, name: name}
+}
+
+// ListenerOptions restrict what clients of particular listener are allowed to do.
+type ListenerOptions struct {
+	// Keys limits identities visible through listener, nil allows all of them.
+	Keys *KeyFilter
+	// ReadOnly allows only listing identities, signing and extensions.
+	ReadOnly bool
+}
+
+type restrictedListener struct {
+	Listener
+	opts ListenerOptions
+}
+
+func (l *restrictedListener) Options() ListenerOptions {
+	return l.opts
+}
+
+// Restrict applies options to clients of ln.
+func Restrict(ln Listener, opts ListenerOptions) Listener {
+	return &restrictedListener{Listener: ln, opts: opts}
+}
+
+// Options returns restrictions applied to ln.
+func Options(ln Listener) ListenerOptions {
+	if rl, ok := ln.(interface{ Options() ListenerOptions }); ok {
+		return rl.Options()
+	}
+	return ListenerOptions{}
+}
+
+// Stats holds server counters.
+type Stats struct {
+	// Rejected is number of client requests refused because of bad framing: empty or oversized messages.
+	Rejected uint64
+}
+
+// Server relays requests from accepted connections to the Backend.
+type Server struct {
+	backend   Backend
+	proxyLock atomic.Bool
+	access    atomic.Int32
+	rejected  atomic.Uint64
+	version   string
+
+	mu        sync.Mutex
+	listeners map[Listener]struct{}
+	conns     map[net.Conn]*Connection
+	lastConn  uint64
+
+	constraints *constraintKeeper
+	audit       audit.Sink
+	events      events.Sink
+	metrics     *Metrics
+	health      atomic.Int32 // backend state seen by requests, for events
+	compat      *compat
+
+	set     *settings // written by options
+	current atomic.Pointer[settings]
+}
+
+// settings are server policies which could be replaced while it runs.
+type settings struct {
+	approver        Approver
+	approvalTimeout time.Duration
+	confirm         *KeyFilter
+	hosts           HostResolver
+	policies        []*HostPolicy
+	noForwarding    *KeyFilter
+}
+
+func newSettings() *settings {
+	return &settings{approvalTimeout: DefaultApprovalTimeout}
+}
+
+// Option configures Server.
+type Option func(*Server)
+
+// NewServer creates Server relaying requests to backend.
+func NewServer(backend Backend, opts ...Option) *Server {
+	s := &Server{backend: backend, listeners: make(map[Listener]struct{}), conns: make(map[net.Conn]*Connection),
+		set: newSettings()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.current.Store(s.set)
+	return s
+}
+
+// Reconfigure replaces server policies with ones given by options while server runs, open connections are kept.
+// Only WithApprover, WithConfirm, WithHostPolicy, WithNoForwarding and WithHosts have effect, policies not given are
+// reset to defaults.
+func (s *Server) Reconfigure(opts ...Option) {
+	tmp := &Server{set: newSettings()}
+	for _, opt := range opts {
+		opt(tmp)
+	}
+	s.current.Store(tmp.set)
+}
+
+func (s *Server) settings() *settings {
+	return s.current.Load()
+}
+
+// Backend returns upstream agent server relays requests to.
+func (s *Server) Backend() Backend {
+	return s.backend
+}
+
+// ClearKeys drops keys held by in-process backend, it reports if backend holds keys in memory. Constraints are kept,
+// they may belong to keys of other agents backend reaches and are replaced when key is added again.
+func (s *Server) ClearKeys() bool {
+	c, ok := s.backend.(Clearer)
+	if ok {
+		c.Clear()
+	}
+	return ok
+}
+
+// Stats returns snapshot of server counters.
+func (s *Server) Stats() Stats {
+	return Stats{Rejected: s.rejected.Load()}
+}
+
+// Serve accepts connections on ln and handles them until ln is closed or fails. It always returns non-nil error.
+func (s *Server) Serve(ln Listener) error {
+
+	s.mu.Lock()
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+	}()
+
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("listener accept error on %s: %w", ln.Name(), err)
+		}
+		go s.handle(conn, ln)
+	}
+}
+
+func (s *Server) handle(conn net.Conn, ln Listener) {
+
+	info := s.track(conn, ln)
+	defer s.untrack(conn)
+	defer conn.Close()
+	if s.events != nil {
+		s.connectionEvent(events.ConnectionOpened, info)
+		defer s.connectionEvent(events.ConnectionClosed, info)
+	}
+	if s.metrics != nil {
+		s.metrics.connection(true)
+		defer s.metrics.connection(false)
+	}
+
+	handle := fmt.Sprintf("conn-%d", info.ID) // same ID connections and events report
+
+	logging.Debugf("[%s] Incoming: %s", handle, conn.LocalAddr())
+
+	c := &client{handle: handle, ln: ln, up: newUpstream(s.backend)}
+	defer c.up.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		logging.Debugf("[%s] Reading loop", handle)
+
+		req, err := readFrame(reader)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				logging.Debugf("[%s] Connection dropped", handle)
+				return
+			}
+			if errors.Is(err, ErrProtocol) {
+				// Misbehaving client - let it know and drop connection
+				s.rejected.Add(1)
+				if s.metrics != nil {
+					s.metrics.protocolError()
+				}
+				logging.Warnf("[%s] Rejecting request: %s", handle, err)
+				_, _ = conn.Write(badResponse[:])
+				return
+			}
+			logging.Debugf("[%s] ReadFull error '%s'", handle, err)
+			return
+		}
+		logging.Debugf("[%s] Got request for query: %d)", handle, len(req)-4)
+
+		start := time.Now()
+		c.refused = ""
+		info.requests.Add(1)
+
+		var sign *events.Event
+		if s.events != nil {
+			sign = s.signRequested(c, info, req)
+		}
+
+		var res []byte
+		reason, kind := s.denied(req)
+		if len(reason) > 0 {
+			res = c.refuse("%s", reason)
+		} else {
+			c.up.waited = 0
+			res, err = s.query(c, req)
+			if s.events != nil && len(c.refused) == 0 {
+				s.backendResult(err)
+			}
+			if err != nil {
+				// If for some reason talking to agent failed send back error
+				logging.Warnf("[%s] query error '%s'", handle, err)
+				res = badResponse[:]
+			}
+			logging.Debugf("[%s] Got query response: %d bytes", handle, len(res))
+		}
+		if s.audit != nil {
+			s.record(c, req, res, err, time.Since(start))
+		}
+		if sign != nil {
+			s.signDone(sign, c, res, err)
+		}
+		if s.metrics != nil {
+			s.metrics.measure(c, req, res, err, kind)
+		}
+
+		_, err = conn.Write(res)
+		if err != nil {
+			logging.Warnf("[%s] Conn.Write error '%s'", handle, err)
+			return
+		}
+		logging.Debugf("[%s] Sent query response back", handle)
+	}
+}
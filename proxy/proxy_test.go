@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/keyring"
+)
+
+// memoryBackend returns in-memory agent for tests.
+func memoryBackend() Backend {
+	return NewLocalBackend(SchemeMemory+":", keyring.New())
+}
+
+// newKey generates ed25519 key ready to be added to agent.
+func newKey(t *testing.T, comment string) *agentproto.AddIdentity {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &agentproto.AddIdentity{
+		KeyType: "ssh-ed25519",
+		Key:     agentproto.AppendBytes(agentproto.AppendBytes(nil, pub), priv),
+		Comment: comment,
+	}
+}
+
+// blob returns public key blob of key produced by newKey.
+func blob(t *testing.T, m *agentproto.AddIdentity) []byte {
+	t.Helper()
+	b, err := m.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// serve starts s on fresh unix socket with opts applied and returns socket path.
+func serve(t *testing.T, s *Server, opts ListenerOptions) string {
+	t.Helper()
+	// t.TempDir could exceed socket path limit
+	dir, err := os.MkdirTemp("", "proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ln Listener = NewListener("test", l)
+	if opts != (ListenerOptions{}) {
+		ln = Restrict(ln, opts)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return path
+}
+
+// call sends single request to proxy listening on path and returns parsed reply.
+func call(t *testing.T, path string, m agentproto.Message) agentproto.Message {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	res, err := roundTrip(conn, "proxy", frame(agentproto.Marshal(m)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := agentproto.Parse(res[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// succeeded reports whether reply is not a failure.
+func succeeded(m agentproto.Message) bool {
+	_, ok := m.(*agentproto.Failure)
+	return !ok
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// maxSessionBinds limits number of session bindings per connection, same as in ssh-agent.
+const maxSessionBinds = 16
+
+// client is state of single client connection.
+type client struct {
+	handle string
+	ln     Listener
+	up     *upstream
+	binds  []*agentproto.SessionBind // verified session bindings in order of hops
+
+	refused string // why current request was refused by proxy
+}
+
+// refuse records why proxy does not let current request through and returns failure reply.
+func (c *client) refuse(format string, args ...any) []byte {
+	c.refused = fmt.Sprintf(format, args...)
+	logging.Infof("[%s] Refused request: %s", c.handle, c.refused)
+	return badResponse[:]
+}
+
+// query relays single request to upstream agent within listener restrictions and server policies.
+func (s *Server) query(c *client, req []byte) ([]byte, error) {
+
+	opts := Options(c.ln)
+	keys := opts.Keys
+	st := s.settings()
+	if keys == nil && !opts.ReadOnly && st.confirm == nil && s.constraints == nil && s.audit == nil && s.events == nil && st.hosts == nil && s.compat == nil &&
+		len(st.policies) == 0 && st.noForwarding == nil && agentproto.MessageType(req[4]) != agentproto.ExtensionMsg {
+		return c.up.query(req)
+	}
+
+	m, err := agentproto.Parse(req[4:])
+	if err != nil {
+		if keys != nil || opts.ReadOnly {
+			// agent could be more lenient and act on it
+			return c.refuse("malformed request: %s", err), nil
+		}
+		return c.up.query(req)
+	}
+
+	if opts.ReadOnly {
+		switch m.(type) {
+		case *agentproto.RequestIdentities, *agentproto.SignRequest, *agentproto.Extension:
+		default:
+			return c.refuse("%s through read-only listener", m.Type()), nil
+		}
+	}
+
+	switch m := m.(type) {
+	case *agentproto.RequestIdentities:
+		return s.identities(c)
+	case *agentproto.SignRequest:
+		if err := s.permitSign(c, m); err != nil {
+			return c.refuse("signing: %s", err), nil
+		}
+		if s.compat != nil {
+			return s.compat.sign(c, m, req)
+		}
+	case *agentproto.AddIdentity:
+		if s.constraints != nil {
+			return s.constraints.add(c, m, s.addKey)
+		}
+		if s.compat != nil {
+			return replyFrame(s.addKey(c, m))
+		}
+	case *agentproto.RemoveIdentity:
+		if keys != nil {
+			if err := keys.check(c.up, m.KeyBlob); err != nil {
+				return c.refuse("key removal: %s", err), nil
+			}
+		}
+		res, err := c.up.query(req)
+		if err == nil && s.constraints != nil && !bytes.Equal(res, badResponse[:]) {
+			s.constraints.set(m.KeyBlob, nil)
+		}
+		return res, err
+	case *agentproto.RemoveAllIdentities:
+		if keys != nil {
+			// would remove keys this listener does not see
+			return c.refuse("removal of all keys through restricted listener"), nil
+		}
+		res, err := c.up.query(req)
+		if err == nil && s.constraints != nil && !bytes.Equal(res, badResponse[:]) {
+			s.constraints.clear()
+		}
+		return res, err
+	case *agentproto.Extension:
+		switch m.Name {
+		case ExtLock:
+			if keys != nil || opts.ReadOnly {
+				// changes state of the whole proxy
+				return c.refuse("%s through restricted listener", ExtLock), nil
+			}
+			return s.extension(c, m)
+		case ExtStatus:
+			return s.extension(c, m)
+		case agentproto.ExtQuery:
+			return s.queryExtensions(c, m)
+		case agentproto.ExtSessionBind:
+			if err := s.bind(c, m); err != nil {
+				return c.refuse("session bind: %s", err), nil
+			}
+			if s.compat != nil {
+				return s.compat.bindExtension(c, m)
+			}
+		}
+	case *agentproto.Unknown:
+		if keys != nil {
+			// legacy and unknown requests may affect keys listener does not see
+			return c.refuse("%s through restricted listener", m.Type()), nil
+		}
+	}
+	return c.up.query(req)
+}
+
+// addKey sends key addition to the agent.
+func (s *Server) addKey(c *client, m *agentproto.AddIdentity) (agentproto.Message, error) {
+	if s.compat != nil {
+		return s.compat.add(c, m)
+	}
+	return c.up.queryMessage(m)
+}
+
+// replyFrame encodes reply to be sent to client.
+func replyFrame(m agentproto.Message, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return frame(agentproto.Marshal(m)), nil
+}
+
+// identities lists keys visible to the client.
+func (s *Server) identities(c *client) ([]byte, error) {
+
+	res, err := c.up.queryMessage(&agentproto.RequestIdentities{})
+	if err != nil {
+		return nil, err
+	}
+	ans, ok := res.(*agentproto.IdentitiesAnswer)
+	if !ok {
+		return frame(agentproto.Marshal(res)), nil
+	}
+
+	keys := Options(c.ln).Keys
+	visible := ans.Keys[:0]
+	for _, id := range ans.Keys {
+		if keys != nil && !keys.Allowed(id) {
+			continue
+		}
+		if s.constraints != nil {
+			if kc := s.constraints.get(id.KeyBlob); kc != nil && kc.permitted(c.binds, nil) != nil {
+				continue
+			}
+		}
+		visible = append(visible, id)
+	}
+	ans.Keys = visible
+	return frame(agentproto.Marshal(ans)), nil
+}
+
+// permitSign applies listener restrictions, key constraints, host policies and confirmation to sign request.
+func (s *Server) permitSign(c *client, m *agentproto.SignRequest) error {
+
+	var kc *keyConstraints
+	if s.constraints != nil {
+		kc = s.constraints.get(m.KeyBlob)
+	}
+	keys := Options(c.ln).Keys
+	st := s.settings()
+	if keys == nil && st.confirm == nil && kc == nil && len(st.policies) == 0 && st.noForwarding == nil {
+		return nil
+	}
+
+	id, err := c.up.identity(m.KeyBlob)
+	if err != nil {
+		return err
+	}
+	if keys != nil && !keys.Allowed(id) {
+		return fmt.Errorf("key %s (%s) is not allowed", agentproto.Fingerprint(id.KeyBlob), id.Comment)
+	}
+	if kc != nil {
+		if err := kc.permitted(c.binds, m.Data); err != nil {
+			return err
+		}
+	}
+	d := c.signedData(m.Data)
+	if err := st.checkPolicy(c, id, d); err != nil {
+		return err
+	}
+	if kc != nil && kc.confirm || st.confirm != nil && st.confirm.Allowed(id) {
+		a := &Approval{Listener: c.ln.Name(), Key: id, Data: d, Host: st.hostName(d.HostKey)}
+		if !ask(st.approver, st.approvalTimeout, c.handle, a) {
+			return errors.New("not confirmed")
+		}
+	}
+	return nil
+}
+
+// signedData decodes data of sign request. Host of user authentication is taken from session binding when client
+// does not use hostbound authentication.
+func (c *client) signedData(data []byte) *agentproto.SignedData {
+	d := agentproto.ParseSignedData(data)
+	if d.Kind == agentproto.SignedUserauth && d.HostKey == nil {
+		for _, b := range c.binds {
+			if bytes.Equal(b.SessionID, d.SessionID) {
+				d.HostKey = b.HostKey
+			}
+		}
+	}
+	return d
+}
+
+// bind verifies and records session binding the way ssh-agent does it.
+func (s *Server) bind(c *client, m *agentproto.Extension) error {
+
+	sb, err := agentproto.ParseSessionBind(m)
+	if err != nil {
+		return err
+	}
+	if err := agentproto.Verify(sb.HostKey, sb.SessionID, sb.Signature); err != nil {
+		return err
+	}
+	for _, b := range c.binds {
+		if bytes.Equal(b.SessionID, sb.SessionID) {
+			if bytes.Equal(b.HostKey, sb.HostKey) && b.Forwarding == sb.Forwarding {
+				// already recorded
+				return nil
+			}
+			return errors.New("session identifier is already bound to different host")
+		}
+	}
+	if n := len(c.binds); n > 0 && !c.binds[n-1].Forwarding {
+		return errors.New("previous binding was for authentication")
+	}
+	if len(c.binds) >= maxSessionBinds {
+		return errors.New("too many session bindings")
+	}
+	c.binds = append(c.binds, sb)
+	if name := s.settings().hostName(sb.HostKey); len(name) > 0 {
+		logging.Debugf("[%s] Bound to host %s (key %s), forwarding %t", c.handle, name, agentproto.Fingerprint(sb.HostKey), sb.Forwarding)
+	} else {
+		logging.Debugf("[%s] Bound to host key %s, forwarding %t", c.handle, agentproto.Fingerprint(sb.HostKey), sb.Forwarding)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// Extensions implemented by proxy itself, they never reach backend agent.
+const (
+	// ExtStatus returns Status as JSON document in a wire string.
+	ExtStatus = "status@wsl-ssh-agent"
+	// ExtLock locks (contents is boolean true) or unlocks (false) agent access through proxy. Unlocking does not lift
+	// lock of the user session.
+	ExtLock = "lock@wsl-ssh-agent"
+)
+
+// Status describes state of the proxy.
+type Status struct {
+	Version       string           `json:"version,omitempty"`
+	Locked        bool             `json:"locked"`         // requests are refused
+	SessionLocked bool             `json:"session_locked"` // user session is locked and session policy limits access
+	ProxyLocked   bool             `json:"proxy_locked"`   // by lock@wsl-ssh-agent extension
+	Access        string           `json:"access"`         // set by session policy: "full", "list" or "none"
+	Backend       BackendStatus    `json:"backend"`
+	Listeners     []ListenerStatus `json:"listeners"`
+	Rejected      uint64           `json:"rejected"`
+}
+
+// BackendStatus describes health of backend agent.
+type BackendStatus struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Keys  int    `json:"keys"`
+	Error string `json:"error,omitempty"`
+}
+
+// ListenerStatus describes active listener.
+type ListenerStatus struct {
+	Name     string `json:"name"`
+	Keys     string `json:"keys,omitempty"` // patterns of visible keys
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// WithVersion sets version reported by status extension.
+func WithVersion(version string) Option {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+// SetLocked changes proxy lock state, locked proxy refuses all requests except lock extension.
+func (s *Server) SetLocked(locked bool) {
+	s.proxyLock.Store(locked)
+}
+
+// Status collects current state of the proxy, backend agent is asked for identities to check its health.
+func (s *Server) Status() *Status {
+
+	st := s.state()
+	if keys, err := s.backendKeys(); err != nil {
+		st.Backend.Error = err.Error()
+	} else {
+		st.Backend.OK, st.Backend.Keys = true, len(keys)
+	}
+
+	s.mu.Lock()
+	for ln := range s.listeners {
+		st.Listeners = append(st.Listeners, listenerStatus(ln))
+	}
+	s.mu.Unlock()
+	sort.Slice(st.Listeners, func(i, j int) bool { return st.Listeners[i].Name < st.Listeners[j].Name })
+	return st
+}
+
+// clientStatus is status as client sees it: its own listener only and number of keys visible to it. Keys are listed
+// through client connection to backend.
+func (s *Server) clientStatus(c *client) *Status {
+
+	st := s.state()
+	st.Listeners = []ListenerStatus{listenerStatus(c.ln)}
+
+	res, err := s.identities(c)
+	if err == nil {
+		var m agentproto.Message
+		if m, err = agentproto.Parse(res[4:]); err == nil {
+			if ans, ok := m.(*agentproto.IdentitiesAnswer); ok {
+				st.Backend.OK, st.Backend.Keys = true, len(ans.Keys)
+			} else {
+				err = fmt.Errorf("unexpected reply %s", m.Type())
+			}
+		}
+	}
+	if err != nil {
+		st.Backend.Error = err.Error()
+	}
+	return st
+}
+
+// state returns status without backend health and listeners.
+func (s *Server) state() *Status {
+	st := &Status{
+		Version:       s.version,
+		SessionLocked: s.sessionLocked(),
+		ProxyLocked:   s.proxyLock.Load(),
+		Access:        s.Access().String(),
+		Backend:       BackendStatus{Name: s.backend.Name()},
+		Rejected:      s.rejected.Load(),
+	}
+	st.Locked = st.SessionLocked || st.ProxyLocked
+	return st
+}
+
+func listenerStatus(ln Listener) ListenerStatus {
+	opts := Options(ln)
+	ls := ListenerStatus{Name: ln.Name(), ReadOnly: opts.ReadOnly}
+	if opts.Keys != nil {
+		ls.Keys = opts.Keys.String()
+	}
+	return ls
+}
+
+// KeyStatus describes identity backend agent holds.
+type KeyStatus struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Comment     string `json:"comment"`
+}
+
+// Keys asks backend agent for identities. Listener key filters do not apply.
+func (s *Server) Keys() ([]KeyStatus, error) {
+	ids, err := s.backendKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]KeyStatus, 0, len(ids))
+	for _, id := range ids {
+		t, _ := agentproto.KeyType(id.KeyBlob)
+		keys = append(keys, KeyStatus{Type: t, Fingerprint: agentproto.Fingerprint(id.KeyBlob), Comment: id.Comment})
+	}
+	return keys, nil
+}
+
+func (s *Server) backendKeys() ([]agentproto.Identity, error) {
+	res, err := s.backend.Query(frame(agentproto.Marshal(&agentproto.RequestIdentities{})))
+	if err != nil {
+		return nil, err
+	}
+	m, err := agentproto.Parse(res[4:])
+	if err != nil {
+		return nil, err
+	}
+	ans, ok := m.(*agentproto.IdentitiesAnswer)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply %s", m.Type())
+	}
+	return ans.Keys, nil
+}
+
+// ownExtension returns name of proxy extension request is for, empty for other requests.
+func ownExtension(req []byte) string {
+	if len(req) < 5 || agentproto.MessageType(req[4]) != agentproto.ExtensionMsg {
+		return ""
+	}
+	m, err := agentproto.Parse(req[4:])
+	if err != nil {
+		return ""
+	}
+	if name := m.(*agentproto.Extension).Name; name == ExtStatus || name == ExtLock {
+		return name
+	}
+	return ""
+}
+
+// extension serves proxy extensions.
+func (s *Server) extension(c *client, m *agentproto.Extension) ([]byte, error) {
+
+	switch m.Name {
+	case ExtStatus:
+		doc, err := json.Marshal(s.clientStatus(c))
+		if err != nil {
+			return nil, err
+		}
+		return frame(agentproto.Marshal(&agentproto.ExtensionResponse{Name: ExtStatus, Contents: agentproto.AppendBytes(nil, doc)})), nil
+	case ExtLock:
+		r := agentproto.NewReader(m.Contents)
+		locked := r.Bool()
+		if err := r.Done(); err != nil {
+			return c.refuse("%s: %s", ExtLock, err), nil
+		}
+		s.SetLocked(locked)
+		logging.Infof("[%s] Proxy lock set to %t", c.handle, locked)
+		return frame(agentproto.Marshal(&agentproto.Success{})), nil
+	}
+	return nil, errors.New("unknown proxy extension " + m.Name)
+}
+
+// queryExtensions answers query extension with extensions of backend agent merged with ones proxy implements.
+func (s *Server) queryExtensions(c *client, m *agentproto.Extension) ([]byte, error) {
+
+	names := []string{agentproto.ExtQuery, ExtStatus, ExtLock}
+	if s.compat != nil {
+		// accepted by proxy even when agent does not know it
+		names = append(names, agentproto.ExtSessionBind)
+	}
+
+	res, err := c.up.queryMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	if backend, err := agentproto.ParseQueryResponse(res); err == nil {
+		names = append(names, backend...)
+	} else if !failed(res) {
+		logging.Warnf("[%s] Bad query reply from agent: %s", c.handle, err)
+	}
+
+	seen := make(map[string]bool)
+	merged := names[:0]
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			merged = append(merged, n)
+		}
+	}
+	return frame(agentproto.Marshal(agentproto.QueryResponse(merged))), nil
+}
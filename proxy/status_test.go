@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"wsl-ssh-agent/agentproto"
+)
+
+func TestProxyExtensions(t *testing.T) {
+	allow, err := NewKeyFilter("*@work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := &agentproto.Extension{Name: ExtLock, Contents: agentproto.AppendBool(nil, true)}
+	status := &agentproto.Extension{Name: ExtStatus}
+
+	tests := []struct {
+		name   string
+		opts   ListenerOptions
+		locked bool // lock extension is accepted
+	}{
+		{name: "unrestricted", locked: true},
+		{name: "read-only", opts: ListenerOptions{ReadOnly: true}},
+		{name: "allow filter", opts: ListenerOptions{Keys: allow}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(memoryBackend())
+			path := serve(t, s, tt.opts)
+
+			if got := call(t, path, status); !succeeded(got) {
+				t.Errorf("status reply %s, want extension response", got.Type())
+			}
+			if got := call(t, path, lock); succeeded(got) != tt.locked {
+				t.Errorf("lock reply %s, want success %t", got.Type(), tt.locked)
+			}
+			if s.Status().ProxyLocked != tt.locked {
+				t.Errorf("proxy locked %t, want %t", s.Status().ProxyLocked, tt.locked)
+			}
+		})
+	}
+}
+
+func TestProxyLockLifted(t *testing.T) {
+	s := NewServer(memoryBackend())
+	path := serve(t, s, ListenerOptions{})
+
+	s.SetLocked(true)
+	if got := call(t, path, &agentproto.RequestIdentities{}); succeeded(got) {
+		t.Errorf("list reply %s while locked, want failure", got.Type())
+	}
+	if got := call(t, path, &agentproto.Extension{Name: ExtLock, Contents: agentproto.AppendBool(nil, false)}); !succeeded(got) {
+		t.Errorf("unlock reply %s, want success", got.Type())
+	}
+	if got := call(t, path, &agentproto.RequestIdentities{}); !succeeded(got) {
+		t.Errorf("list reply %s after unlock, want identities", got.Type())
+	}
+}
+
+func TestStatusExtension(t *testing.T) {
+	allow, err := NewKeyFilter("*@work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(memoryBackend())
+	path := serve(t, s, ListenerOptions{})
+	filtered := serve(t, s, ListenerOptions{Keys: allow})
+	for _, name := range []string{"me@work", "me@home"} {
+		if got := call(t, path, newKey(t, name)); !succeeded(got) {
+			t.Fatalf("add %s reply %s", name, got.Type())
+		}
+	}
+
+	status := func(path string) *Status {
+		t.Helper()
+		res, ok := call(t, path, &agentproto.Extension{Name: ExtStatus}).(*agentproto.ExtensionResponse)
+		if !ok {
+			return nil
+		}
+		st := new(Status)
+		if err := json.Unmarshal(agentproto.NewReader(res.Contents).Bytes(), st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	st := status(filtered)
+	if st == nil || len(st.Listeners) != 1 || st.Listeners[0].Keys != allow.String() || st.Backend.Keys != 1 {
+		t.Errorf("status through filtered listener %+v, want own listener and 1 key", st)
+	}
+	if st := status(path); st == nil || len(st.Listeners) != 1 || st.Listeners[0].Keys != "" || st.Backend.Keys != 2 {
+		t.Errorf("status through unrestricted listener %+v, want own listener and 2 keys", st)
+	}
+
+	tests := []struct {
+		name   string
+		access Access
+		locked bool
+		served bool
+	}{
+		{name: "list access", access: AccessList, served: true},
+		{name: "no access", access: AccessNone},
+		{name: "session locked", access: AccessNone, locked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetAccess(tt.access, tt.locked)
+			defer s.SetAccess(AccessFull, false)
+			if st := status(path); (st != nil) != tt.served {
+				t.Errorf("status %+v, want served %t", st, tt.served)
+			}
+		})
+	}
+
+	s.SetLocked(true)
+	defer s.SetLocked(false)
+	if st := status(path); st != nil {
+		t.Errorf("status %+v while proxy is locked", st)
+	}
+}
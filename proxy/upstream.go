@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"wsl-ssh-agent/agentproto"
+	"wsl-ssh-agent/logging"
+)
+
+// roundTrip sends single framed request over conn and reads single framed reply.
+func roundTrip(conn net.Conn, name string, req []byte) ([]byte, error) {
+
+	l, err := conn.Write(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write to %s: %w", name, err)
+	}
+	logging.Debugf("Sent to %s: %d", name, l)
+
+	res, err := readFrame(conn)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%w: connection closed without reply", ErrProtocol)
+		}
+		return nil, fmt.Errorf("cannot read from %s: %w", name, err)
+	}
+	logging.Debugf("Received from %s: %d", name, len(res))
+	return res, nil
+}
+
+// upstream keeps single backend connection for the whole life of client connection, so we do not pay for connection
+// setup on every request and stateful exchanges (like session binding) reach the same agent connection. Broken
+// connection is transparently re-established.
+type upstream struct {
+	backend Backend
+	agent   Backend // one connection leads to, differs from backend for fallback
+	conn    net.Conn
+	waited  time.Duration // spent talking to backend, for metrics
+}
+
+func newUpstream(backend Backend) *upstream {
+	return &upstream{backend: backend}
+}
+
+func (u *upstream) query(req []byte) ([]byte, error) {
+
+	start := time.Now()
+	defer func() { u.waited += time.Since(start) }()
+
+	reused := u.conn != nil
+	if !reused {
+		if err := u.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := roundTrip(u.conn, u.backend.Name(), req)
+	if err != nil && reused {
+		// Connection we kept around may have been closed by agent in the meantime - reconnect and try again once
+		logging.Debugf("Reconnecting to %s: %s", u.backend.Name(), err)
+		if err := u.connect(); err != nil {
+			return nil, err
+		}
+		res, err = roundTrip(u.conn, u.backend.Name(), req)
+	}
+	if err != nil {
+		u.Close()
+	}
+	return res, err
+}
+
+func (u *upstream) connect() error {
+	u.Close()
+	conn, err := u.backend.Dial()
+	if err != nil {
+		return err
+	}
+	u.agent = u.backend
+	if ac, ok := conn.(*agentConn); ok {
+		u.agent = ac.agent
+	}
+	logging.Debugf("Connected to %s", u.agent.Name())
+	u.conn = conn
+	return nil
+}
+
+// holder returns name of the agent which handles requests for the key: the one connection leads to or, when it
+// aggregates several agents, the one known to hold the key.
+func (u *upstream) holder(blob []byte) string {
+	if u.conn == nil {
+		if err := u.connect(); err != nil {
+			return u.backend.Name()
+		}
+	}
+	if m, ok := u.agent.(*multiBackend); ok && blob != nil {
+		if _, ok := m.owner(blob); !ok {
+			// listing records owners
+			_, _ = u.queryMessage(&agentproto.RequestIdentities{})
+		}
+		if i, ok := m.owner(blob); ok {
+			return m.backends[i].Name()
+		}
+	}
+	return u.agent.Name()
+}
+
+// Close releases backend connection if any.
+func (u *upstream) Close() {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+}
+
+// queryMessage sends parsed request and parses reply.
+func (u *upstream) queryMessage(req agentproto.Message) (agentproto.Message, error) {
+	res, err := u.query(frame(agentproto.Marshal(req)))
+	if err != nil {
+		return nil, err
+	}
+	m, err := agentproto.Parse(res[4:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad reply from %s: %w", ErrProtocol, u.backend.Name(), err)
+	}
+	return m, nil
+}
+
+// identity finds key in agent list, comment is needed to apply policies and to ask for confirmation.
+func (u *upstream) identity(blob []byte) (agentproto.Identity, error) {
+	res, err := u.queryMessage(&agentproto.RequestIdentities{})
+	if err != nil {
+		return agentproto.Identity{}, err
+	}
+	ans, ok := res.(*agentproto.IdentitiesAnswer)
+	if !ok {
+		return agentproto.Identity{}, fmt.Errorf("unexpected reply %s to identities request", res.Type())
+	}
+	for _, id := range ans.Keys {
+		if bytes.Equal(id.KeyBlob, blob) {
+			return id, nil
+		}
+	}
+	return agentproto.Identity{}, fmt.Errorf("key %s is not known to agent", agentproto.Fingerprint(blob))
+}
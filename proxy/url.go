@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"wsl-ssh-agent/keyring"
+)
+
+// Backend URL schemes.
+const (
+	SchemePipe   = "npipe"
+	SchemeUnix   = "unix"
+	SchemeTCP    = "tcp"
+	SchemeMemory = "memory"
+)
+
+// splitURL returns scheme and address of "scheme:address" or "scheme://address". Windows drive letters are not mistaken
+// for schemes.
+func splitURL(uri string) (scheme, address string) {
+	i := strings.Index(uri, ":")
+	if i <= 1 {
+		return "", uri
+	}
+	return strings.ToLower(uri[:i]), strings.TrimPrefix(uri[i+1:], "//")
+}
+
+// pipeName converts "//./pipe/name" into "\\.\pipe\name".
+func pipeName(address string) (string, error) {
+	name := strings.ReplaceAll(address, "/", `\`)
+	if !strings.HasPrefix(name, `\\`) {
+		name = `\\` + strings.TrimLeft(name, `\`)
+	}
+	if !strings.Contains(name, `\pipe\`) {
+		return "", fmt.Errorf("bad pipe name %q", address)
+	}
+	return name, nil
+}
+
+// ParseBackend creates Backend from URL. Supported forms are:
+//
+//	npipe://./pipe/openssh-ssh-agent - Windows named pipe, plain pipe name without scheme is accepted as well
+//	unix:/path/agent.sock            - AF_UNIX socket
+//	tcp:127.0.0.1:port               - TCP connection
+//	memory:                          - in-process agent keeping keys in memory
+func ParseBackend(uri string) (Backend, error) {
+
+	scheme, address := splitURL(uri)
+	switch scheme {
+	case "", SchemePipe:
+		name, err := pipeName(address)
+		if err != nil {
+			return nil, err
+		}
+		return NewPipeBackend(name), nil
+	case SchemeUnix:
+		if len(address) == 0 {
+			return nil, fmt.Errorf("socket path is missing in %q", uri)
+		}
+		return NewBackend(SchemeUnix+":"+address, func() (net.Conn, error) {
+			return net.Dial("unix", address)
+		}), nil
+	case SchemeTCP:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("bad tcp address in %q: %w", uri, err)
+		}
+		return NewBackend(SchemeTCP+":"+address, func() (net.Conn, error) {
+			return net.Dial("tcp", address)
+		}), nil
+	case SchemeMemory:
+		return NewLocalBackend(SchemeMemory+":", keyring.New()), nil
+	}
+	return nil, fmt.Errorf("unsupported backend %q", uri)
+}
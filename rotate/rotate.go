@@ -0,0 +1,112 @@
+// Package rotate implements file which is rotated when it grows over size limit, used for logs and audit records.
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File appends data to file renaming it to path.1 when it grows over size limit, previous path.1 becomes path.2 and
+// so on, only configured number of old files are retained. Writes of single Write call are never split between files.
+type File struct {
+	what    string // file is used for, in errors
+	path    string
+	maxSize int64
+	keep    int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+// Open opens (or creates) file and its directory, what describes file purpose in errors ("log", "audit").
+func Open(what, path string, maxSize int64, keep int) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create %s directory: %w", what, err)
+	}
+	rf := &File{what: what, path: path, maxSize: maxSize, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Path returns name of the current file.
+func (rf *File) Path() string {
+	return rf.path
+}
+
+func (rf *File) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open %s file: %w", rf.what, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("unable to open %s file: %w", rf.what, err)
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *File) rotate() error {
+	rf.f.Close()
+	rf.f = nil
+	for i := rf.keep - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.keep > 0 {
+		_ = os.Rename(rf.path, rf.path+".1")
+	} else {
+		_ = os.Remove(rf.path)
+	}
+	if err := rf.open(); err != nil {
+		return fmt.Errorf("unable to rotate %s file: %w", rf.what, err)
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p does not fit. After Close it fails with os.ErrClosed.
+func (rf *File) Write(p []byte) (int, error) {
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.closed {
+		return 0, os.ErrClosed
+	}
+	if rf.f == nil {
+		// previous rotation failed, try again
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("unable to write %s file: %w", rf.what, err)
+	}
+	return n, nil
+}
+
+// Close closes file, writes afterwards fail.
+func (rf *File) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.closed = true
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,116 @@
+package rotate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func content(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		keep  int
+		files map[string]string // suffix -> content
+		gone  string
+	}{
+		{name: "keep two", keep: 2, files: map[string]string{"": "eeee\n", ".1": "cccc\ndddd\n", ".2": "aaaa\nbbbb\n"}, gone: ".3"},
+		{name: "keep one", keep: 1, files: map[string]string{"": "eeee\n", ".1": "cccc\ndddd\n"}, gone: ".2"},
+		{name: "keep none", keep: 0, files: map[string]string{"": "eeee\n"}, gone: ".1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub", "test.log")
+			rf, err := Open("test", path, 10, tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rf.Close()
+			// two lines fit, every third one starts new file
+			for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"} {
+				if _, err := rf.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for suffix, want := range tt.files {
+				if got := content(t, path+suffix); got != want {
+					t.Errorf("file%s %q, want %q", suffix, got, want)
+				}
+			}
+			if _, err := os.Stat(path + tt.gone); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("file%s exists", tt.gone)
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := Open("test", path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.Write([]byte("new\n"))
+	rf.Write([]byte("next\n")) // existing content counts towards size
+	rf.Close()
+	if got := content(t, path+".1"); got != "old\nnew\n" {
+		t.Errorf("rotated file %q", got)
+	}
+	if got := content(t, path); got != "next\n" {
+		t.Errorf("current file %q", got)
+	}
+}
+
+func TestLargeWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	rf, err := Open("test", path, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	// larger than limit lands in empty file whole
+	rf.Write([]byte("0123456789\n"))
+	if _, err := os.Stat(path + ".1"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("empty file was rotated")
+	}
+	if got := content(t, path); got != "0123456789\n" {
+		t.Errorf("current file %q", got)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	rf, err := Open("test", path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write after Close: %v, want %v", err, os.ErrClosed)
+	}
+	if rf.Close() != nil {
+		t.Error("second Close failed")
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	dir := t.TempDir()
+	// directory in place of the file
+	if _, err := Open("test", dir, 10, 1); err == nil {
+		t.Error("Open of directory succeeded")
+	}
+}
@@ -0,0 +1,238 @@
+// Package session changes agent access when user session state changes: it is locked, remote desktop connects and so on.
+package session
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"wsl-ssh-agent/proxy"
+)
+
+// Event is user session state change.
+type Event string
+
+// Session events, names follow WTS_* notifications.
+const (
+	ConsoleConnect    Event = "console-connect"
+	ConsoleDisconnect Event = "console-disconnect"
+	RemoteConnect     Event = "remote-connect"
+	RemoteDisconnect  Event = "remote-disconnect"
+	Logon             Event = "logon"
+	Logoff            Event = "logoff"
+	Lock              Event = "lock"
+	Unlock            Event = "unlock"
+	RemoteControl     Event = "remote-control"
+)
+
+var events = []Event{ConsoleConnect, ConsoleDisconnect, RemoteConnect, RemoteDisconnect, Logon, Logoff, Lock, Unlock, RemoteControl}
+
+// Action is what is done on event.
+type Action string
+
+// Actions.
+const (
+	Allow           Action = "allow"            // give full access back
+	DenySign        Action = "deny-sign"        // only listing of keys is allowed
+	DenyAll         Action = "deny-all"         // every request is refused
+	ClearKeys       Action = "clear-keys"       // remove keys from in-memory agent
+	DropConnections Action = "drop-connections" // close open client connections
+	Notify          Action = "notify"           // show notification
+)
+
+var actions = []Action{Allow, DenySign, DenyAll, ClearKeys, DropConnections, Notify}
+
+// UnmarshalText accepts known action names only.
+func (a *Action) UnmarshalText(text []byte) error {
+	v := Action(text)
+	if !known(actions, v) {
+		return fmt.Errorf("unknown session action %q", v)
+	}
+	*a = v
+	return nil
+}
+
+// Known reports if name is one of session events.
+func (e Event) Known() bool {
+	return known(events, e)
+}
+
+// Rules hold actions for events, they are performed in order.
+type Rules map[Event][]Action
+
+// DefaultRules deny agent access and clear in-memory keys while session is locked.
+func DefaultRules() Rules {
+	return Rules{
+		Lock:   {DenyAll, ClearKeys},
+		Unlock: {Allow},
+	}
+}
+
+// Add parses rule "event=action,action..." and replaces actions of the event. Empty action list removes rule.
+func (r Rules) Add(rule string) error {
+	name, list, ok := strings.Cut(rule, "=")
+	if !ok {
+		return fmt.Errorf("session rule %q is not in event=actions form", rule)
+	}
+	e := Event(strings.TrimSpace(name))
+	if !e.Known() {
+		return fmt.Errorf("unknown session event %q", e)
+	}
+	var acts []Action
+	for _, a := range strings.Split(list, ",") {
+		a := Action(strings.TrimSpace(a))
+		if len(a) == 0 {
+			continue
+		}
+		if !known(actions, a) {
+			return fmt.Errorf("unknown session action %q", a)
+		}
+		acts = append(acts, a)
+	}
+	if len(acts) == 0 {
+		delete(r, e)
+	} else {
+		r[e] = acts
+	}
+	return nil
+}
+
+// String returns rules in the form Add accepts separated by spaces.
+func (r Rules) String() string {
+	var list []string
+	for e, acts := range r {
+		names := make([]string, 0, len(acts))
+		for _, a := range acts {
+			names = append(names, string(a))
+		}
+		list = append(list, string(e)+"="+strings.Join(names, ","))
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+func known[T comparable](list []T, v T) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// condition is part of session state events change, pairs of opposite events share it.
+type condition string
+
+var conditions = map[Event]condition{
+	Lock:              "lock",
+	Unlock:            "lock",
+	RemoteConnect:     "remote",
+	RemoteDisconnect:  "remote",
+	RemoteControl:     "remote",
+	ConsoleConnect:    "console",
+	ConsoleDisconnect: "console",
+	Logon:             "logon",
+	Logoff:            "logon",
+}
+
+// Engine performs actions of rules on the server when session events arrive. Access actions set access level of the
+// event condition (lock, remote, console, logon) and server gets the most restrictive level of all conditions, so
+// unlocking session does not lift limits set when remote desktop connected. It is safe for concurrent use.
+type Engine struct {
+	server *proxy.Server
+	notify func(text string)
+
+	mu     sync.Mutex
+	rules  Rules
+	levels map[condition]proxy.Access
+	state  map[condition]Event // last event of every condition
+}
+
+// New creates Engine, notify shows notification to the user and could be nil.
+func New(server *proxy.Server, rules Rules, notify func(text string)) *Engine {
+	return &Engine{server: server, rules: rules, notify: notify, levels: make(map[condition]proxy.Access), state: make(map[condition]Event)}
+}
+
+// SetRules replaces rules and recomputes access level as if new rules were in place when current session state was
+// reached: condition gets access of the last access action its last event has, full access when there is none.
+func (e *Engine) SetRules(rules Rules) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	for c, ev := range e.state {
+		level := proxy.AccessFull
+		for _, a := range rules[ev] {
+			if l, ok := accessOf(a); ok {
+				level = l
+			}
+		}
+		e.levels[c] = level
+	}
+	e.apply()
+}
+
+// Rules returns current rules.
+func (e *Engine) Rules() Rules {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rules
+}
+
+// Handle performs actions for event.
+func (e *Engine) Handle(ev Event) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.state[conditions[ev]] = ev
+	acts := e.rules[ev]
+	if len(acts) == 0 {
+		return
+	}
+	log.Printf("Session event %s: %v", ev, acts)
+
+	for _, a := range acts {
+		if l, ok := accessOf(a); ok {
+			e.levels[conditions[ev]] = l
+			e.apply()
+			continue
+		}
+		switch a {
+		case ClearKeys:
+			// do not keep keys in memory while nobody is watching
+			if e.server.ClearKeys() {
+				log.Print("Cleared in-memory keys")
+			}
+		case DropConnections:
+			log.Printf("Dropped %d client connection(s)", e.server.DropConnections())
+		case Notify:
+			if e.notify != nil {
+				e.notify(fmt.Sprintf("Agent access is %s after session %s", e.server.Access(), ev))
+			}
+		}
+	}
+}
+
+// accessOf returns access level action sets, ok is false for other actions.
+func accessOf(a Action) (proxy.Access, bool) {
+	switch a {
+	case Allow:
+		return proxy.AccessFull, true
+	case DenySign:
+		return proxy.AccessList, true
+	case DenyAll:
+		return proxy.AccessNone, true
+	}
+	return proxy.AccessFull, false
+}
+
+// apply gives server the most restrictive level of all conditions.
+func (e *Engine) apply() {
+	level := proxy.AccessFull
+	for _, l := range e.levels {
+		level = max(level, l)
+	}
+	e.server.SetAccess(level, level != proxy.AccessFull && e.levels["lock"] == level)
+}
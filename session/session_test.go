@@ -0,0 +1,181 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"wsl-ssh-agent/proxy"
+)
+
+func TestRulesAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		want  string
+		err   bool
+	}{
+		{name: "defaults", want: "lock=deny-all,clear-keys unlock=allow"},
+		{name: "replace", rules: []string{"lock=deny-sign"}, want: "lock=deny-sign unlock=allow"},
+		{name: "remove", rules: []string{"lock="}, want: "unlock=allow"},
+		{name: "spaces", rules: []string{" remote-connect = deny-sign , notify "}, want: "lock=deny-all,clear-keys remote-connect=deny-sign,notify unlock=allow"},
+		{name: "no equal sign", rules: []string{"lock"}, err: true},
+		{name: "unknown event", rules: []string{"suspend=deny-all"}, err: true},
+		{name: "unknown action", rules: []string{"lock=explode"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := DefaultRules()
+			var err error
+			for _, rule := range tt.rules {
+				if err = r.Add(rule); err != nil {
+					break
+				}
+			}
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Add(%q) succeeded, want error", tt.rules)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Add(%q): %v", tt.rules, err)
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("rules = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActionUnmarshal(t *testing.T) {
+	var a Action
+	if err := a.UnmarshalText([]byte("deny-sign")); err != nil || a != DenySign {
+		t.Errorf("UnmarshalText(deny-sign) = %q, %v", a, err)
+	}
+	if err := a.UnmarshalText([]byte("deny")); err == nil {
+		t.Error("UnmarshalText(deny) succeeded, want error")
+	}
+}
+
+func TestEngine(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  []string
+		events []Event
+		access proxy.Access
+		locked bool // access is limited by session lock
+	}{
+		{name: "no events", access: proxy.AccessFull},
+		{name: "lock", events: []Event{Lock}, access: proxy.AccessNone, locked: true},
+		{name: "lock unlock", events: []Event{Lock, Unlock}, access: proxy.AccessFull},
+		{
+			name:   "remote desktop keeps limit after unlock",
+			rules:  []string{"remote-connect=deny-sign", "remote-disconnect=allow"},
+			events: []Event{Lock, RemoteConnect, Unlock},
+			access: proxy.AccessList,
+		},
+		{
+			name:   "remote desktop disconnect",
+			rules:  []string{"remote-connect=deny-sign", "remote-disconnect=allow"},
+			events: []Event{Lock, RemoteConnect, Unlock, RemoteDisconnect},
+			access: proxy.AccessFull,
+		},
+		{
+			name:   "lock is more restrictive than remote",
+			rules:  []string{"remote-connect=deny-sign"},
+			events: []Event{RemoteConnect, Lock},
+			access: proxy.AccessNone,
+			locked: true,
+		},
+		{
+			name:   "lock as restrictive as remote",
+			rules:  []string{"remote-connect=deny-sign", "lock=deny-sign"},
+			events: []Event{RemoteConnect, Lock},
+			access: proxy.AccessList,
+			locked: true,
+		},
+		{name: "no rules", rules: []string{"lock=", "unlock="}, events: []Event{Lock}, access: proxy.AccessFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := DefaultRules()
+			for _, rule := range tt.rules {
+				if err := rules.Add(rule); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := proxy.NewServer(proxy.NewBackend("none", func() (net.Conn, error) {
+				return nil, errors.New("no agent")
+			}))
+			e := New(server, rules, nil)
+			for _, ev := range tt.events {
+				e.Handle(ev)
+			}
+			if got := server.Access(); got != tt.access {
+				t.Errorf("access after %v = %s, want %s", tt.events, got, tt.access)
+			}
+			if got := server.Status().SessionLocked; got != tt.locked {
+				t.Errorf("session locked after %v = %t, want %t", tt.events, got, tt.locked)
+			}
+		})
+	}
+}
+
+func TestEngineNotify(t *testing.T) {
+	rules := Rules{}
+	if err := rules.Add("remote-connect=deny-sign,notify"); err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	e := New(proxy.NewServer(proxy.NewBackend("none", func() (net.Conn, error) {
+		return nil, errors.New("no agent")
+	})), rules, func(text string) { texts = append(texts, text) })
+	e.Handle(RemoteConnect)
+	e.Handle(Lock) // no rule
+	want := "Agent access is list after session remote-connect"
+	if len(texts) != 1 || texts[0] != want {
+		t.Errorf("notifications = %q, want [%q]", texts, want)
+	}
+}
+
+func TestEngineSetRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		rules  []string // replace default rules
+		access proxy.Access
+		locked bool
+	}{
+		{name: "empty rules while locked", events: []Event{Lock}, access: proxy.AccessFull},
+		{name: "weaker rule while locked", events: []Event{Lock}, rules: []string{"lock=deny-sign"}, access: proxy.AccessList, locked: true},
+		{name: "unlocked session", events: []Event{Lock, Unlock}, rules: []string{"lock=deny-all"}, access: proxy.AccessFull},
+		{name: "rule for current state", events: []Event{RemoteConnect}, rules: []string{"remote-connect=deny-all"}, access: proxy.AccessNone},
+		{name: "no events", rules: []string{"lock=deny-all"}, access: proxy.AccessFull},
+		{name: "last access action wins", events: []Event{Lock}, rules: []string{"lock=deny-all,deny-sign,notify"}, access: proxy.AccessList, locked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := proxy.NewServer(proxy.NewBackend("none", func() (net.Conn, error) {
+				return nil, errors.New("no agent")
+			}))
+			e := New(server, DefaultRules(), nil)
+			for _, ev := range tt.events {
+				e.Handle(ev)
+			}
+			rules := Rules{}
+			for _, rule := range tt.rules {
+				if err := rules.Add(rule); err != nil {
+					t.Fatal(err)
+				}
+			}
+			e.SetRules(rules)
+			if got := server.Access(); got != tt.access {
+				t.Errorf("access after %v and rules %v = %s, want %s", tt.events, tt.rules, got, tt.access)
+			}
+			if got := server.Status().SessionLocked; got != tt.locked {
+				t.Errorf("session locked = %t, want %t", got, tt.locked)
+			}
+		})
+	}
+}
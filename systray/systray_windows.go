@@ -269,6 +269,38 @@ func (t *winTray) setTooltip(src string) error {
 	return t.nid.modify()
 }
 
+// Shows balloon notification near the icon, long text is truncated.
+// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
+func (t *winTray) showNotification(title, text string) error {
+	const (
+		NIF_INFO  = 0x00000010
+		NIIF_INFO = 0x00000001
+	)
+	tb, err := windows.UTF16FromString(title)
+	if err != nil {
+		return err
+	}
+	b, err := windows.UTF16FromString(text)
+	if err != nil {
+		return err
+	}
+
+	t.muNID.Lock()
+	defer t.muNID.Unlock()
+	t.nid.InfoTitle = [64]uint16{}
+	copy(t.nid.InfoTitle[:len(t.nid.InfoTitle)-1], tb)
+	t.nid.Info = [256]uint16{}
+	copy(t.nid.Info[:len(t.nid.Info)-1], b)
+	t.nid.InfoFlags = NIIF_INFO
+	t.nid.Flags |= NIF_INFO
+	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
+
+	err = t.nid.modify()
+	// do not show it again on next modification
+	t.nid.Flags &^= NIF_INFO
+	return err
+}
+
 var wt winTray
 
 // WindowProc callback function that processes messages sent to a window.
@@ -1000,6 +1032,14 @@ func SetTooltip(tooltip string) {
 	}
 }
 
+// ShowNotification shows balloon (or toast) notification from the systray icon.
+func ShowNotification(title, text string) {
+	if err := wt.showNotification(title, text); err != nil {
+		log.Printf("Unable to show notification: %v", err)
+		return
+	}
+}
+
 func addOrUpdateMenuItem(item *MenuItem) {
 	err := wt.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.title, item.disabled, item.checked)
 	if err != nil {
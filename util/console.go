@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package util
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+const attachParentProcess = ^uintptr(0) // ATTACH_PARENT_PROCESS
+
+// AttachConsole lets GUI program print into console of the process which started it. Standard handles parent
+// redirected (to pipe or file) are left alone.
+func AttachConsole() {
+
+	proc := kernel.NewProc("AttachConsole")
+	if r, _, _ := proc.Call(attachParentProcess); r == 0 {
+		return
+	}
+	for _, std := range []struct {
+		handle uint32
+		file   **os.File
+	}{
+		{windows.STD_OUTPUT_HANDLE, &os.Stdout},
+		{windows.STD_ERROR_HANDLE, &os.Stderr},
+	} {
+		if h, err := windows.GetStdHandle(std.handle); err == nil && h != 0 && h != windows.InvalidHandle {
+			continue
+		}
+		if f, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0); err == nil {
+			*std.file = f
+		}
+	}
+}
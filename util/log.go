@@ -5,7 +5,6 @@ package util
 
 import (
 	"io"
-	"log"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -13,25 +12,15 @@ import (
 
 var kernel = windows.NewLazySystemDLL("kernel32")
 
-// DebugWriter redirects all output to OutputDebugString().
+// logWriter sends all output to OutputDebugString().
 type logWriter struct {
 	proc *windows.LazyProc
 }
 
-// NewLogWriter redirects all log output depending on debug parameetr.
-// When true all output goes to OutputDebugString and you could use debugger or Sysinternals dbgview.exe to collect it.
-// When false - everything is discarded.
-func NewLogWriter(title string, flags int, debug bool) {
-
-	log.SetPrefix("[" + title + "] ")
-	log.SetFlags(flags)
-
-	if debug {
-		res := &logWriter{proc: kernel.NewProc("OutputDebugStringW")}
-		log.SetOutput(res)
-	} else {
-		log.SetOutput(io.Discard)
-	}
+// NewDebugWriter returns writer which sends output to OutputDebugString, you could use debugger or Sysinternals
+// dbgview.exe to collect it.
+func NewDebugWriter() io.Writer {
+	return &logWriter{proc: kernel.NewProc("OutputDebugStringW")}
 }
 
 func (l *logWriter) Write(p []byte) (n int, err error) {
@@ -5,6 +5,7 @@ package util
 
 import (
 	"log"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -35,3 +36,31 @@ func ShowOKMessage(t MsgType, title, text string) {
 		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(title))),
 		uintptr(mb))
 }
+
+// ShowYesNoMessage shows MB_YESNO question which closes itself after timeout. It returns true only when user
+// answered "Yes", "No" is default answer.
+func ShowYesNoMessage(title, text string, timeout time.Duration) bool {
+
+	const (
+		mbYesNo         = 0x00000004
+		mbIconQuestion  = 0x00000020
+		mbDefButton2    = 0x00000100
+		mbSystemModal   = 0x00001000
+		mbSetForeground = 0x00010000
+		idYes           = 6
+	)
+
+	var (
+		mod = windows.NewLazySystemDLL("user32")
+		// undocumented but present in every Windows version since XP
+		proc = mod.NewProc("MessageBoxTimeoutW")
+		mb   = mbYesNo | mbIconQuestion | mbDefButton2 | mbSystemModal | mbSetForeground
+	)
+	ret, _, _ := proc.Call(0,
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(text))),
+		uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(title))),
+		uintptr(mb),
+		0,
+		uintptr(timeout.Milliseconds()))
+	return ret == idYes
+}
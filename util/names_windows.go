@@ -0,0 +1,10 @@
+//go:build windows
+
+package util
+
+import (
+	"syscall"
+)
+
+// MaxNameLen is maximum length of AF_UNIX socket path.
+const MaxNameLen = syscall.UNIX_PATH_MAX